@@ -4,11 +4,56 @@ import (
 	"os"
 )
 
+// setDefault задает значение переменной окружения, только если она не задана
+func setDefault(key, value string) {
+	if os.Getenv(key) == "" {
+		os.Setenv(key, value)
+	}
+}
+
 func ConfigSetup() {
-	os.Setenv("user", "postgres")
-	os.Setenv("password", "qwe")
-	os.Setenv("dbname", "WBTechDatabase")
-	os.Setenv("sslmode", "disable")
-	os.Setenv("CACHE_SIZE", "10")
-	os.Setenv("APP_KEY", "WB-1")
+	setDefault("user", "postgres")
+	setDefault("password", "qwe")
+	setDefault("dbname", "WBTechDatabase")
+	setDefault("sslmode", "disable")
+	setDefault("CACHE_SIZE", "10")
+	setDefault("CACHE_MAX_BYTES", "0") // Бюджет кэша в байтах, 0 - без ограничения
+	setDefault("CACHE_POLICY", "fifo") // fifo, lru, lfu или ttl
+	setDefault("CACHE_TTL", "10m")     // Срок жизни элементов для политики ttl
+	setDefault("CACHE_SNAPSHOT_PATH", "cache.snapshot")
+	setDefault("CACHE_SNAPSHOT_MAX_AGE", "1h")
+	setDefault("CACHE_WRITER_BATCH_SIZE", "100")  // Размер пачки записи состава кэша в wb_scheme.cache
+	setDefault("CACHE_WRITER_INTERVAL", "1s")     // Максимальная задержка записи пачки
+	setDefault("CACHE_WRITER_QUEUE", "1000")      // Размер очереди записи
+	setDefault("CACHE_WRITER_RETRY_MAX", "30s")   // Максимальная пауза между повторными попытками записи пачки
+	setDefault("CACHE_WRITER_ENQUEUE_WAIT", "0s") // Ожидание места в заполненной очереди записи, 0 - не ждать
+	setDefault("DB_READ_TIMEOUT", "5s")           // Предельное время чтения заказов
+	setDefault("DB_WRITE_TIMEOUT", "10s")         // Предельное время сохранения заказа и записи состава кэша
+	setDefault("DB_BATCH_TIMEOUT", "1m")          // Предельное время сохранения пачки заказов и восстановления кэша
+	setDefault("DB_CONNECT_BACKOFF_MAX", "30s")   // Максимальная пауза между попытками подключения к базе данных при запуске
+	// Каждый экземпляр сервиса должен запускаться со своим APP_KEY
+	setDefault("APP_KEY", "WB-1")
+	setDefault("SHUTDOWN_TIMEOUT", "30s")               // Время на корректное завершение работы
+	setDefault("BATCH_GET_MAX_UIDS", "5000")            // Максимальное количество заказов в одном запросе batchGet
+	setDefault("NATS_SERVERS", "nats://127.0.0.1:4222") // Адреса серверов NATS через запятую
+	setDefault("NATS_SUBJECT", "intros")                // Канал, из которого принимаются заказы
+	// NATS_CREDS_FILE, NATS_NKEY_FILE, NATS_TLS_CERT, NATS_TLS_KEY и NATS_TLS_CA
+	// по умолчанию не заданы
+	setDefault("NATS_RECONNECT_WAIT", "2s")                    // Пауза между попытками переподключения
+	setDefault("NATS_MAX_RECONNECTS", "-1")                    // Количество попыток переподключения, -1 - без ограничения
+	setDefault("NATS_CONNECT_ATTEMPTS", "10")                  // Количество попыток подключения при запуске
+	setDefault("NATS_CONNECT_BACKOFF_MAX", "30s")              // Максимальная пауза между попытками подключения при запуске
+	setDefault("NATS_DLQ_SUBJECT", "intros.dlq")               // Канал недоставленных сообщений
+	setDefault("NATS_DURABLE", "orders-service")               // Имя постоянного подписчика JetStream
+	setDefault("NATS_QUEUE_GROUP", "orders-service")           // Группа экземпляров сервиса, между которыми распределяются заказы
+	setDefault("NATS_INVALIDATE_SUBJECT", "intros.invalidate") // Канал сброса кэша между экземплярами сервиса
+	setDefault("NATS_MAX_DELIVER", "5")                        // Максимальное количество попыток доставки сообщения
+	setDefault("NATS_ACK_WAIT", "30s")                         // Время на обработку сообщения до повторной доставки
+	setDefault("NATS_NAK_DELAY", "5s")                         // Задержка повторной доставки после временной ошибки базы данных
+	setDefault("NATS_WORKERS", "4")                            // Количество обработчиков сообщений
+	setDefault("NATS_WORKER_QUEUE", "100")                     // Размер очереди сообщений, ожидающих обработчика
+	setDefault("NATS_BATCH_SIZE", "1")                         // Количество заказов, сохраняемых одной транзакцией, 1 - по одному
+	setDefault("NATS_BATCH_WAIT", "100ms")                     // Максимальное время набора пачки
+	// Неподтвержденные сообщения всех экземпляров сервиса вместе, применяется при создании подписчика и командой consumer update
+	setDefault("NATS_MAX_ACK_PENDING", "1000")
 }
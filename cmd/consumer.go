@@ -0,0 +1,51 @@
+package main
+
+import (
+	"WBTech_L0/internal/streaming"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+// runConsumer выполняет команду consumer: show (по умолчанию) или update.
+// Параметры постоянного подписчика общие для всех экземпляров сервиса, поэтому сервис только создает
+// подписчика при первом запуске, а менять их нужно этой командой.
+func runConsumer(args []string) {
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	conn, err := streaming.Connect(context.Background(), streaming.ConnConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Ошибка при подключении к NATS: %v", err)
+	}
+	defer conn.Close()
+
+	js, err := conn.JetStream()
+	if err != nil {
+		log.Fatalf("JetStream недоступен: %v", err)
+	}
+
+	cfg := streaming.ConsumerConfigFromEnv()
+	var info *nats.ConsumerInfo
+	switch action {
+	case "show":
+		info, err = streaming.ConsumerInfo(js, cfg)
+	case "update":
+		if err = streaming.EnsureOrdersStream(js); err != nil {
+			break
+		}
+		info, err = streaming.UpdateConsumer(js, cfg)
+	default:
+		err = fmt.Errorf("неизвестное действие %q, используйте show или update", action)
+	}
+
+	if err != nil {
+		log.Fatalf("consumer: %v", err)
+	}
+	fmt.Printf("Подписчик %s: ack wait %v, max deliver %d, max ack pending %d, неподтвержденных сообщений %d\n",
+		info.Name, info.Config.AckWait, info.Config.MaxDeliver, info.Config.MaxAckPending, info.NumAckPending)
+}
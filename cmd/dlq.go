@@ -0,0 +1,76 @@
+package main
+
+import (
+	"WBTech_L0/internal/streaming"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// runDeadLetter выполняет команду dlq: list (по умолчанию) или replay <номер>|all.
+func runDeadLetter(args []string) {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	conn, err := streaming.Connect(context.Background(), streaming.ConnConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Ошибка при подключении к NATS: %v", err)
+	}
+	defer conn.Close()
+
+	js, err := conn.JetStream()
+	if err != nil {
+		log.Fatalf("JetStream недоступен: %v", err)
+	}
+
+	switch action {
+	case "list":
+		var letters []streaming.DeadLetter
+		letters, err = streaming.ListDeadLetters(js)
+		for _, letter := range letters {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n", letter.Sequence, letter.Time.Format(time.RFC3339), letter.Subject, letter.Stage, letter.Reason)
+		}
+		if err == nil {
+			fmt.Printf("Недоставленных сообщений: %d\n", len(letters))
+		}
+	case "replay":
+		if len(args) < 2 {
+			err = fmt.Errorf("укажите номер сообщения или all")
+			break
+		}
+		if args[1] == "all" {
+			var letters []streaming.DeadLetter
+			letters, err = streaming.ListDeadLetters(js)
+			if err != nil {
+				// Частичный список не отправляется, чтобы ошибка не потерялась за успешными отправками
+				break
+			}
+			for _, letter := range letters {
+				if err = streaming.ReplayDeadLetter(js, letter.Sequence); err != nil {
+					break
+				}
+				fmt.Printf("Сообщение %d отправлено в %s\n", letter.Sequence, letter.Subject)
+			}
+			break
+		}
+		var seq uint64
+		seq, err = strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("некорректный номер сообщения %q", args[1])
+			break
+		}
+		if err = streaming.ReplayDeadLetter(js, seq); err == nil {
+			fmt.Printf("Сообщение %d отправлено повторно\n", seq)
+		}
+	default:
+		err = fmt.Errorf("неизвестное действие %q, используйте list или replay", action)
+	}
+
+	if err != nil {
+		log.Fatalf("dlq: %v", err)
+	}
+}
@@ -5,10 +5,20 @@ import (
 	"WBTech_L0/cmd/configuration"
 	"WBTech_L0/internal/database"
 	"WBTech_L0/internal/streaming"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	// Импортируем пакеты для инициализации
 	_ "WBTech_L0/cmd/configuration"
@@ -22,17 +32,49 @@ func main() {
 	// Выполняем настройку конфигурации приложения
 	configuration.ConfigSetup()
 
-	// Создаем экземпляр базы данных
+	// Команда migrate применяет миграции схемы базы данных и завершает работу
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// Команда dlq показывает и повторно отправляет недоставленные сообщения
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		runDeadLetter(os.Args[2:])
+		return
+	}
+
+	// Команда consumer показывает и обновляет параметры постоянного подписчика JetStream
+	if len(os.Args) > 1 && os.Args[1] == "consumer" {
+		runConsumer(os.Args[2:])
+		return
+	}
+
+	// Перехватываем сигналы завершения, чтобы остановить сервис корректно
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Создаем экземпляр базы данных. Соединение устанавливается позже, поэтому сервис
+	// запускается, даже если PostgreSQL медленно отвечает или переключается на резервный сервер
 	dbInstance, err := database.NewDB()
-	if err == nil {
-		fmt.Println("База данных подключена!")
+	if err != nil {
+		log.Fatalf("Не удалось создать подключение к базе данных: %v", err)
 	}
 
-	// Создаем экземпляр кэша
-	csh := database.NewCache(dbInstance)
+	// Создаем экземпляр кэша: заказы хранятся в PostgreSQL, состав кэша - в wb_scheme.cache.
+	// Кэш сразу загружается из снимка, и заказы из него отдаются до готовности базы данных
+	csh := database.NewCache(database.NewPostgresRepository(dbInstance), database.NewOrderCacheStore(dbInstance))
 
-	// Инициализируем потоковую обработку данных
-	streaming.NewStream(dbInstance)
+	// Потоковая обработка запускается после готовности базы данных, чтобы заказы из NATS
+	// не уходили в канал недоставленных сообщений, пока база данных недоступна
+	var stream atomic.Pointer[streaming.Streaming]
+	started := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		defer close(started)
+		if err := start(ctx, dbInstance, csh, &stream); err != nil && ctx.Err() == nil {
+			failed <- err
+		}
+	}()
 
 	// Создаем маршрутизатор для обработки HTTP-запросов
 	r := mux.NewRouter()
@@ -42,12 +84,108 @@ func main() {
 	r.HandleFunc("/api/getOrderInfo/{orderUID}", func(w http.ResponseWriter, r *http.Request) {
 		GettingOrderInfo(w, r, csh)
 	}).Methods("GET")
+	r.HandleFunc("/api/orders", func(w http.ResponseWriter, r *http.Request) {
+		ListingOrders(w, r, csh.Repo)
+	}).Methods("GET")
+	r.HandleFunc("/api/orders/{orderUID}", func(w http.ResponseWriter, r *http.Request) {
+		DeletingOrder(w, r, stream.Load())
+	}).Methods("DELETE")
+	r.HandleFunc("/api/orders:batchGet", func(w http.ResponseWriter, r *http.Request) {
+		BatchGettingOrders(w, r, csh)
+	}).Methods("POST")
+	r.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		GettingStats(w, r, csh, dbInstance, stream.Load())
+	}).Methods("GET")
+
+	// Создаем HTTP-сервер
+	server := &http.Server{Addr: ":8080", Handler: r}
+
+	go func() {
+		fmt.Println("Сервер работает на порту :8080...")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Ошибка HTTP-сервера: %v", err)
+		}
+	}()
+
+	// Ожидаем сигнал завершения. Ошибка запуска, которую не исправить повторными попытками
+	// (несовпадение версии схемы, недоступный NATS), тоже завершает работу, но после сохранения снимка кэша
+	var startErr error
+	select {
+	case <-ctx.Done():
+	case startErr = <-failed:
+		log.Printf("Не удалось запустить сервис: %v", startErr)
+	}
+	// Повторный сигнал завершает процесс сразу, не дожидаясь корректного завершения работы
+	stop()
+
+	shutdown(server, started, &stream, csh, dbInstance)
+	if startErr != nil {
+		os.Exit(1)
+	}
+}
+
+// start в фоне подключается к базе данных, проверяет версию схемы и закрепляет APP_KEY,
+// повторяя попытки до успеха или завершения ctx. После этого восстанавливает кэш из
+// wb_scheme.cache, если он не был загружен из снимка, и запускает потоковую обработку.
+// До готовности базы данных промахи кэша отдаются клиенту с кодом 503.
+// Возвращает ошибку, если запуск прерван ctx, версия схемы базы данных не совпадает с ожидаемой
+// или не удалось подключиться к NATS.
+func start(ctx context.Context, dbInstance *database.DB, csh *database.OrderCache, stream *atomic.Pointer[streaming.Streaming]) error {
+	if err := dbInstance.WaitReady(ctx); err != nil {
+		return err
+	}
+	fmt.Println("База данных подключена!")
+
+	csh.Restore(ctx)
+
+	// Инициализируем потоковую обработку данных
+	s, err := streaming.NewStream(ctx, csh)
+	if err != nil {
+		return err
+	}
+	stream.Store(s)
+	return nil
+}
+
+// shutdown корректно завершает работу сервиса в пределах SHUTDOWN_TIMEOUT:
+// прекращает прием HTTP-запросов, дожидается обработки полученных из NATS сообщений,
+// сохраняет состояние кэша и закрывает соединение с базой данных.
+func shutdown(server *http.Server, started <-chan struct{}, stream *atomic.Pointer[streaming.Streaming], csh *database.OrderCache, dbInstance *database.DB) {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil {
+		timeout = 30 * time.Second
+	}
+	log.Printf("Завершение работы (не более %v)...", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Не удалось корректно остановить HTTP-сервер: %v", err)
+	}
 
-	// Настроим обработку корневого URL
-	http.Handle("/", r)
+	// Запуск прерывается вместе с контекстом сигнала, но подключение к NATS может еще идти
+	select {
+	case <-started:
+	case <-ctx.Done():
+		log.Printf("Не удалось дождаться завершения запуска сервиса: %v", ctx.Err())
+	}
+
+	if s := stream.Load(); s != nil {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Не удалось дождаться обработки сообщений NATS: %v", err)
+		}
+	}
+
+	if err := csh.Finish(ctx); err != nil {
+		log.Printf("Не удалось сохранить снимок кэша: %v", err)
+	}
+
+	if err := dbInstance.Close(ctx); err != nil {
+		log.Printf("Не удалось закрыть соединение с базой данных: %v", err)
+	}
 
-	fmt.Println("Сервер работает на порту :8080...")
-	http.ListenAndServe(":8080", nil)
+	log.Println("Сервис остановлен")
 }
 
 // GettingOrderInfoByOrderUID обрабатывает запрос для получения информации о заказе по его уникальному идентификатору (OrderUID).
@@ -66,7 +204,9 @@ func GettingOrderInfoByOrderUID(w http.ResponseWriter, r *http.Request) {
 }
 
 // GettingOrderInfo обрабатывает запрос для получения информации о заказе по его уникальному идентификатору (OrderUID).
-func GettingOrderInfo(w http.ResponseWriter, r *http.Request, dbInstance *database.Cache) {
+// Заказ сначала ищется в кэше, а при промахе загружается из базы данных и сохраняется в кэш.
+// Заголовок X-Cache сообщает, откуда был получен ответ: HIT - из кэша, MISS - из базы данных.
+func GettingOrderInfo(w http.ResponseWriter, r *http.Request, csh *database.OrderCache) {
 	// Устанавливаем заголовок Content-Type для ответа
 	w.Header().Set("Content-Type", "application/json")
 
@@ -74,33 +214,233 @@ func GettingOrderInfo(w http.ResponseWriter, r *http.Request, dbInstance *databa
 	vars := mux.Vars(r)
 	orderUID := vars["orderUID"]
 
-	// Получаем информацию о заказе из кэша
-	orderFetch, err := dbInstance.DBInst.GetOrderByUid(orderUID)
+	if !database.ValidUID(orderUID) {
+		writeDatabaseError(w, database.ErrInvalidUID)
+		return
+	}
 
-	if err != nil {
-		// В случае ошибки возвращаем статус "500 Internal Server Error"
-		http.Error(w, "Не удалось получить информацию о заказе из базы данных", http.StatusInternalServerError)
+	// Ищем заказ в кэше
+	if order, exists := csh.Get(orderUID); exists {
+		w.Header().Set("X-Cache", "HIT")
+		json.NewEncoder(w).Encode(order)
 		return
 	}
 
-	// Создаем структуру Order для ответа
-	order := database.Order{
-		OrderUID:          orderFetch.OrderUID,
-		TrackNumber:       orderFetch.TrackNumber,
-		Entry:             orderFetch.Entry,
-		Delivery:          orderFetch.Delivery,
-		Payment:           orderFetch.Payment,
-		Items:             orderFetch.Items,
-		Locale:            orderFetch.Locale,
-		InternalSignature: orderFetch.InternalSignature,
-		CustomerID:        orderFetch.CustomerID,
-		DeliveryService:   orderFetch.DeliveryService,
-		Shardkey:          orderFetch.Shardkey,
-		SMID:              orderFetch.SMID,
-		DateCreated:       orderFetch.DateCreated,
-		OofShard:          orderFetch.OofShard,
+	// Заказа нет в кэше: загружаем его из базы данных
+	order, err := csh.Load(r.Context(), orderUID)
+	if err != nil {
+		// Ошибка отправляется с кодом и статусом, соответствующими ее причине
+		writeDatabaseError(w, err)
+		return
 	}
 
 	// Кодируем структуру в JSON и отправляем клиенту
+	w.Header().Set("X-Cache", "MISS")
 	json.NewEncoder(w).Encode(order)
 }
+
+// maxListLimit - максимальный размер страницы в GET /api/orders.
+const maxListLimit = 500
+
+// ListingOrders обрабатывает запрос для поиска заказов с фильтрами и постраничной выдачей по курсору.
+// Параметры запроса: track_number, customer_id, phone, email, transaction,
+// created_from и created_to (RFC 3339), sort (date_created, -date_created, order_uid, -order_uid),
+// limit и cursor (значение next_cursor из предыдущего ответа).
+func ListingOrders(w http.ResponseWriter, r *http.Request, repo database.OrderRepository) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	filter := database.OrderFilter{
+		TrackNumber: query.Get("track_number"),
+		Phone:       query.Get("phone"),
+		Email:       query.Get("email"),
+		Transaction: query.Get("transaction"),
+		Cursor:      query.Get("cursor"),
+		Limit:       database.DefaultListLimit,
+	}
+
+	if value := query.Get("customer_id"); value != "" {
+		customerID, err := strconv.Atoi(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Некорректный customer_id")
+			return
+		}
+		filter.CustomerID = &customerID
+	}
+
+	for name, dest := range map[string]**time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if value := query.Get(name); value != "" {
+			date, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Некорректный %s, ожидается дата в формате RFC 3339", name))
+				return
+			}
+			*dest = &date
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Некорректный limit, допустимо от 1 до %d", maxListLimit))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	sortBy := query.Get("sort")
+	filter.Desc = strings.HasPrefix(sortBy, "-")
+	filter.SortBy = strings.TrimPrefix(sortBy, "-")
+	if filter.SortBy != "" && filter.SortBy != database.SortByDateCreated && filter.SortBy != database.SortByOrderUID {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Некорректный sort, допустимо date_created или order_uid")
+		return
+	}
+
+	page, err := repo.List(r.Context(), filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Некорректный cursor")
+		return
+	}
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+// DeletingOrder обрабатывает запрос для удаления заказа по его уникальному идентификатору (OrderUID).
+// Заказ удаляется из базы данных и кэша, а остальным экземплярам сервиса через NATS отправляется
+// сообщение evict, чтобы они удалили заказ из своего кэша. Пока потоковая обработка не запущена
+// (stream равен nil), сообщение отправить некуда, поэтому запрос отклоняется с кодом 503.
+func DeletingOrder(w http.ResponseWriter, r *http.Request, stream *streaming.Streaming) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orderUID := mux.Vars(r)["orderUID"]
+	if !database.ValidUID(orderUID) {
+		writeDatabaseError(w, database.ErrInvalidUID)
+		return
+	}
+	if stream == nil {
+		writeDatabaseError(w, database.ErrUnavailable)
+		return
+	}
+
+	if err := stream.DeleteOrder(r.Context(), orderUID); err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// batchGetRequest описывает тело запроса POST /api/orders:batchGet.
+type batchGetRequest struct {
+	OrderUIDs []string `json:"order_uids"`
+}
+
+// batchGetResponse описывает ответ POST /api/orders:batchGet.
+type batchGetResponse struct {
+	Orders  []database.Order `json:"orders"`
+	Missing []string         `json:"missing"`
+}
+
+// BatchGettingOrders обрабатывает запрос для получения нескольких заказов по списку OrderUID.
+// Заказы берутся из кэша, а не найденные в кэше загружаются из хранилища заказов csh.Repo одним запросом.
+// Массовые выгрузки не должны влиять на кэш: обращения к нему не учитываются в счетчиках попаданий
+// и промахов и в политике вытеснения, а заказы, загруженные из хранилища, не помещаются в кэш,
+// чтобы не вытеснять из него часто запрашиваемые заказы. В ответе заказы идут в порядке запроса, а отсутствующие OrderUID
+// перечислены в поле missing.
+func BatchGettingOrders(w http.ResponseWriter, r *http.Request, csh *database.OrderCache) {
+	w.Header().Set("Content-Type", "application/json")
+
+	maxUIDs, err := strconv.Atoi(os.Getenv("BATCH_GET_MAX_UIDS"))
+	if err != nil || maxUIDs <= 0 {
+		maxUIDs = 5000
+	}
+
+	var req batchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Некорректное тело запроса")
+		return
+	}
+	if len(req.OrderUIDs) > maxUIDs {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Слишком много идентификаторов заказов, максимум %d", maxUIDs))
+		return
+	}
+	for _, uid := range req.OrderUIDs {
+		if !database.ValidUID(uid) {
+			writeError(w, http.StatusBadRequest, codeInvalidUID, fmt.Sprintf("Некорректный идентификатор заказа: %q", uid))
+			return
+		}
+	}
+
+	// Убираем повторы, сохраняя порядок запроса
+	uids := make([]string, 0, len(req.OrderUIDs))
+	seen := make(map[string]bool, len(req.OrderUIDs))
+	for _, uid := range req.OrderUIDs {
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+
+	found := make(map[string]database.Order, len(uids))
+	var notCached []string
+	for _, uid := range uids {
+		if order, exists := csh.Peek(uid); exists {
+			found[uid] = order
+		} else {
+			notCached = append(notCached, uid)
+		}
+	}
+
+	if len(notCached) > 0 {
+		orders, err := csh.Repo.GetMany(r.Context(), notCached)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		for uid, order := range orders {
+			found[uid] = order
+		}
+	}
+
+	resp := batchGetResponse{
+		Orders:  make([]database.Order, 0, len(found)),
+		Missing: []string{},
+	}
+	for _, uid := range uids {
+		if order, exists := found[uid]; exists {
+			resp.Orders = append(resp.Orders, order)
+		} else {
+			resp.Missing = append(resp.Missing, uid)
+		}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GettingStats возвращает готовность базы данных, счетчики работы кэша (попадания, промахи и вытеснения),
+// записи его состава в базу данных, подключения к NATS и обработки сообщений.
+// Пока потоковая обработка не запущена (stream равен nil), счетчики NATS нулевые.
+func GettingStats(w http.ResponseWriter, r *http.Request, csh *database.OrderCache, dbInstance *database.DB, stream *streaming.Streaming) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats := struct {
+		DatabaseReady bool                      `json:"database_ready"`
+		Cache         database.CacheStats       `json:"cache"`
+		CacheWriter   database.CacheWriterStats `json:"cache_writer"`
+		NATS          streaming.ConnStats       `json:"nats"`
+		Workers       streaming.WorkerStats     `json:"workers"`
+	}{
+		DatabaseReady: dbInstance.Ready(),
+		Cache:         csh.Stats(),
+		CacheWriter:   dbInstance.CacheWriterStats(),
+	}
+	if stream != nil {
+		stats.NATS = stream.Stats()
+		stats.Workers = stream.WorkerStats()
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/database/repotest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestMain отключает журнал сервиса, который пишет строку на каждую операцию кэша.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// failingRepository хранит заказы в памяти, но не может прочитать несколько заказов сразу.
+type failingRepository struct {
+	*database.MemoryRepository
+}
+
+func (r failingRepository) GetMany(context.Context, []string) (map[string]database.Order, error) {
+	return nil, fmt.Errorf("%w: соединение потеряно", database.ErrUnavailable)
+}
+
+// newTestCache создает кэш заказов без снимка и хранилища состава поверх repo.
+func newTestCache(t *testing.T, repo database.OrderRepository) *database.OrderCache {
+	t.Helper()
+	t.Setenv("CACHE_SIZE", "10")
+	t.Setenv("CACHE_POLICY", database.PolicyLRU)
+	t.Setenv("CACHE_SNAPSHOT_PATH", "")
+	return database.NewCache(repo, nil)
+}
+
+// batchGet выполняет POST /api/orders:batchGet с телом body.
+func batchGet(t *testing.T, csh *database.OrderCache, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/orders:batchGet", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	BatchGettingOrders(rec, req, csh)
+	return rec
+}
+
+// errorCode возвращает код ошибки из тела ответа.
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("ответ не в формате ошибки: %v", err)
+	}
+	return resp.Error.Code
+}
+
+// Заказы из кэша и из хранилища возвращаются в порядке запроса, отсутствующие перечисляются в missing.
+// Запрос не влияет на счетчики кэша и порядок вытеснения.
+func TestBatchGetMixedFoundAndMissing(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	csh := newTestCache(t, repo)
+
+	for _, uid := range []string{"cached-old", "cached-new", "stored"} {
+		if _, err := repo.Add(context.Background(), repotest.Order(uid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csh.Set("cached-old", repotest.Order("cached-old"))
+	csh.Set("cached-new", repotest.Order("cached-new"))
+
+	rec := batchGet(t, csh, `{"order_uids": ["stored", "missing", "cached-old", "stored", "cached-new"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("статус %d, ожидается 200: %s", rec.Code, rec.Body)
+	}
+
+	var resp batchGetResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, order := range resp.Orders {
+		got = append(got, order.OrderUID)
+	}
+	if want := []string{"stored", "cached-old", "cached-new"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("заказы %v, ожидается %v", got, want)
+	}
+	if fmt.Sprint(resp.Missing) != fmt.Sprint([]string{"missing"}) {
+		t.Fatalf("missing %v, ожидается [missing]", resp.Missing)
+	}
+
+	if stats := csh.Stats(); stats.Hits != 0 || stats.Misses != 0 || stats.Size != 2 {
+		t.Fatalf("hits %d, misses %d, size %d; batchGet не должен менять счетчики и состав кэша",
+			stats.Hits, stats.Misses, stats.Size)
+	}
+	// Обращение к cached-old в batchGet не продлевает его жизнь в LRU: он вытесняется первым
+	for i := 0; i < 9; i++ {
+		csh.Set(fmt.Sprintf("filler-%d", i), repotest.Order(fmt.Sprintf("filler-%d", i)))
+	}
+	if _, ok := csh.Peek("cached-old"); ok {
+		t.Fatal("cached-old должен быть вытеснен первым: batchGet не должен влиять на порядок вытеснения")
+	}
+	if _, ok := csh.Peek("cached-new"); !ok {
+		t.Fatal("cached-new не должен быть вытеснен")
+	}
+}
+
+// Пустой список возвращает пустой ответ, слишком длинный отклоняется.
+func TestBatchGetListSize(t *testing.T) {
+	csh := newTestCache(t, database.NewMemoryRepository())
+	t.Setenv("BATCH_GET_MAX_UIDS", "2")
+
+	rec := batchGet(t, csh, `{"order_uids": []}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("пустой список: статус %d, ожидается 200", rec.Code)
+	}
+	var resp batchGetResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || len(resp.Orders) != 0 || len(resp.Missing) != 0 {
+		t.Fatalf("пустой список: ответ %+v, %v; ожидается пустой ответ", resp, err)
+	}
+
+	rec = batchGet(t, csh, `{"order_uids": ["a", "b", "c"]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("слишком длинный список: статус %d, ожидается 400", rec.Code)
+	}
+	if code := errorCode(t, rec); code != codeInvalidRequest {
+		t.Fatalf("слишком длинный список: код %q, ожидается %q", code, codeInvalidRequest)
+	}
+}
+
+// Некорректный идентификатор или тело запроса отклоняются с кодом 400.
+func TestBatchGetInvalidRequest(t *testing.T) {
+	csh := newTestCache(t, database.NewMemoryRepository())
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"invalid uid", `{"order_uids": ["ok", "not ok!"]}`, codeInvalidUID},
+		{"invalid json", `{"order_uids": `, codeInvalidRequest},
+	}
+	for _, tt := range tests {
+		rec := batchGet(t, csh, tt.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: статус %d, ожидается 400", tt.name, rec.Code)
+		}
+		if code := errorCode(t, rec); code != tt.code {
+			t.Fatalf("%s: код %q, ожидается %q", tt.name, code, tt.code)
+		}
+	}
+}
+
+// Если хранилище недоступно, возвращается 503, даже если часть заказов есть в кэше.
+func TestBatchGetRepositoryUnavailable(t *testing.T) {
+	csh := newTestCache(t, failingRepository{database.NewMemoryRepository()})
+	csh.Set("cached", repotest.Order("cached"))
+
+	rec := batchGet(t, csh, `{"order_uids": ["cached", "stored"]}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("статус %d, ожидается 503", rec.Code)
+	}
+	if code := errorCode(t, rec); code != codeUnavailable {
+		t.Fatalf("код %q, ожидается %q", code, codeUnavailable)
+	}
+}
+
+// Удаление отклоняется с кодом 400 для некорректного идентификатора и с кодом 503, пока потоковая обработка не запущена.
+func TestDeleteOrderRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		uid    string
+		status int
+		code   string
+	}{
+		{"invalid uid", "not ok!", http.StatusBadRequest, codeInvalidUID},
+		{"stream not started", "valid-uid", http.StatusServiceUnavailable, codeUnavailable},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodDelete, "/api/orders/x", nil)
+		req = mux.SetURLVars(req, map[string]string{"orderUID": tt.uid})
+		rec := httptest.NewRecorder()
+		DeletingOrder(rec, req, nil)
+
+		if rec.Code != tt.status {
+			t.Fatalf("%s: статус %d, ожидается %d", tt.name, rec.Code, tt.status)
+		}
+		if code := errorCode(t, rec); code != tt.code {
+			t.Fatalf("%s: код %q, ожидается %q", tt.name, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"WBTech_L0/internal/database"
+	"context"
+	"fmt"
+	"log"
+)
+
+// runMigrate выполняет команду migrate: up (по умолчанию), down или version.
+func runMigrate(args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	dbInstance, err := database.NewDB()
+	if err != nil {
+		log.Fatalf("Не удалось подключиться к базе данных: %v", err)
+	}
+	defer dbInstance.Close(context.Background())
+
+	switch action {
+	case "up":
+		err = dbInstance.MigrateUp(context.Background())
+	case "down":
+		err = dbInstance.MigrateDown(context.Background())
+	case "version":
+		var version int
+		version, err = dbInstance.SchemaVersion(context.Background())
+		if err == nil {
+			fmt.Printf("Версия схемы базы данных: %d\n", version)
+		}
+	default:
+		err = fmt.Errorf("неизвестное действие %q, используйте up, down или version", action)
+	}
+
+	if err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"WBTech_L0/internal/database"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Коды ошибок в ответах API.
+const (
+	codeNotFound       = "not_found"
+	codeInvalidUID     = "invalid_uid"
+	codeInvalidRequest = "invalid_request"
+	codeUnavailable    = "unavailable"
+	codeInternal       = "internal"
+	codeCanceled       = "canceled"
+)
+
+// statusClientClosedRequest - нестандартный статус 499: клиент закрыл соединение, не дождавшись ответа.
+const statusClientClosedRequest = 499
+
+// errorResponse описывает тело ответа с ошибкой:
+// {"error": {"code": "not_found", "message": "заказ не найден"}}.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// errorBody содержит машиночитаемый код ошибки и сообщение для человека.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError отправляет клиенту ошибку в формате errorResponse.
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Code: code, Message: message}})
+}
+
+// writeDatabaseError отправляет клиенту ошибку пакета database с соответствующим HTTP-статусом:
+// ErrNotFound - 404, ErrInvalidUID - 400, ErrUnavailable - 503, остальные - 500.
+// Если запрос отменен, потому что клиент закрыл соединение, отправляется 499 без записи в журнал.
+func writeDatabaseError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		writeError(w, statusClientClosedRequest, codeCanceled, "Запрос отменен клиентом")
+	case errors.Is(err, database.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "Заказ не найден")
+	case errors.Is(err, database.ErrInvalidUID):
+		writeError(w, http.StatusBadRequest, codeInvalidUID, "Некорректный идентификатор заказа")
+	case errors.Is(err, database.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "База данных временно недоступна")
+	default:
+		log.Printf("Внутренняя ошибка: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Внутренняя ошибка сервера")
+	}
+}
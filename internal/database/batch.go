@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// BatchResult содержит результат сохранения одного заказа пачки.
+type BatchResult struct {
+	OrderUID string
+	Status   IngestStatus
+	Err      error
+}
+
+// AddOrdersBatch сохраняет пачку заказов в одной транзакции. Платежи, доставки, товары и заказы
+// записываются через COPY, поэтому время записи почти не зависит от количества заказов в пачке.
+// Уже сохраненные заказы и повторы внутри пачки получают статус IngestDuplicate.
+// Если пачку записать не удалось, заказы сохраняются по одному через AddOrderInfo,
+// чтобы ошибка одного заказа не мешала сохранить остальные. Результаты возвращаются в порядке orders.
+func (db *DB) AddOrdersBatch(ctx context.Context, orders []Order) []BatchResult {
+	results, err := db.insertBatch(ctx, orders)
+	if err == nil {
+		log.Printf("%v: пачка из %d заказов сохранена в базе данных\n", db.name, len(orders))
+		return results
+	}
+
+	log.Printf("%v: не удалось сохранить пачку из %d заказов, заказы будут сохранены по одному: %v\n", db.name, len(orders), err)
+	results = make([]BatchResult, len(orders))
+	for i, order := range orders {
+		status, err := db.AddOrderInfo(ctx, order)
+		results[i] = BatchResult{OrderUID: order.OrderUID, Status: status, Err: err}
+	}
+	return results
+}
+
+// insertBatch записывает новые заказы пачки в одной транзакции.
+func (db *DB) insertBatch(ctx context.Context, orders []Order) ([]BatchResult, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Batch)
+	defer cancel()
+
+	results := make([]BatchResult, len(orders))
+	uids := make([]string, len(orders))
+	for i, order := range orders {
+		results[i] = BatchResult{OrderUID: order.OrderUID, Status: IngestDuplicate}
+		uids[i] = order.OrderUID
+	}
+
+	tx, err := db.sqlDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокировки берутся в порядке order_uid, чтобы параллельные пачки не ждали друг друга по кругу
+	_, err = tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(hashtext(uid))
+		FROM (SELECT DISTINCT uid FROM unnest($1::text[]) AS uid ORDER BY uid) AS locked`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT order_uid FROM wb_scheme.orders WHERE order_uid = ANY($1)`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(orders))
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		seen[uid] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Новые заказы пачки, каждый order_uid - один раз
+	var pending []int
+	itemCount := 0
+	for i, order := range orders {
+		if seen[order.OrderUID] {
+			continue
+		}
+		seen[order.OrderUID] = true
+		pending = append(pending, i)
+		itemCount += len(order.Items)
+	}
+	if len(pending) == 0 {
+		return results, tx.Commit()
+	}
+
+	// Идентификаторы выделяются заранее, чтобы связать строки разных таблиц без RETURNING
+	paymentIDs, err := nextIDs(ctx, tx, "wb_scheme.payment", "id", len(pending))
+	if err != nil {
+		return nil, err
+	}
+	deliveryIDs, err := nextIDs(ctx, tx, "wb_scheme.delivery", "id", len(pending))
+	if err != nil {
+		return nil, err
+	}
+	itemIDs, err := nextIDs(ctx, tx, "wb_scheme.items", "item_id", itemCount)
+	if err != nil {
+		return nil, err
+	}
+
+	var payments, deliveries, items, ordersRows, orderItems [][]any
+	item := 0
+	for n, i := range pending {
+		order := orders[i]
+		p, d := order.Payment, order.Delivery
+
+		payments = append(payments, []any{paymentIDs[n], p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
+			p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee})
+		deliveries = append(deliveries, []any{deliveryIDs[n], d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email})
+		for _, it := range order.Items {
+			items = append(items, []any{itemIDs[item], it.ChrtID, it.TrackNumber, it.Price, it.RID, it.Name, it.Sale, it.Size,
+				it.TotalPrice, it.NmID, it.Brand, it.Status})
+			orderItems = append(orderItems, []any{order.OrderUID, itemIDs[item]})
+			item++
+		}
+		ordersRows = append(ordersRows, []any{order.OrderUID, paymentIDs[n], deliveryIDs[n], order.TrackNumber, order.Entry,
+			order.Locale, order.InternalSignature, order.DeliveryService, order.Shardkey, order.SMID, order.DateCreated,
+			order.OofShard, order.CustomerID})
+
+		results[i].Status = IngestInserted
+	}
+
+	copies := []struct {
+		table   string
+		columns []string
+		rows    [][]any
+	}{
+		{"payment", []string{"id", "transaction", "request_id", "currency", "provider", "amount", "payment_dt", "bank",
+			"delivery_cost", "goods_total", "custom_fee"}, payments},
+		{"delivery", []string{"id", "name", "phone", "zip", "city", "address", "region", "email"}, deliveries},
+		{"items", []string{"item_id", "chrt_id", "track_number", "price", "rid", "name", "sale", "size", "total_price",
+			"nm_id", "brand", "status"}, items},
+		{"orders", []string{"order_uid", "payment_id", "delivery_id", "track_number", "entry", "locale", "internal_signature",
+			"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "customer_id"}, ordersRows},
+		{"order_items", []string{"order_uid", "item_id"}, orderItems},
+	}
+	for _, c := range copies {
+		if err := copyRows(ctx, tx, c.table, c.columns, c.rows); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// nextIDs выделяет count значений последовательности столбца column таблицы table.
+func nextIDs(ctx context.Context, tx *sql.Tx, table string, column string, count int) ([]int64, error) {
+	ids := make([]int64, 0, count)
+	if count == 0 {
+		return ids, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT nextval(pg_get_serial_sequence($1, $2)) FROM generate_series(1, $3)`, table, column, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// copyRows записывает строки rows в таблицу wb_scheme.table через COPY.
+func copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema("wb_scheme", table, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	// Вызов без аргументов завершает COPY
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
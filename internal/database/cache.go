@@ -1,104 +1,544 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// CacheStore сохраняет состав кэша, чтобы восстановить его после перезапуска.
+type CacheStore[K comparable, V any] interface {
+	// Restore возвращает сохраненные ключи от старых к новым и значения для них.
+	Restore(ctx context.Context, size int) ([]K, map[K]V, error)
+	// Added сообщает о появлении нового ключа в кэше.
+	Added(key K)
+	// Evicted сообщает о вытеснении ключа из кэша.
+	Evicted(key K)
+}
+
+// CacheLoader загружает значение при промахе кэша.
+type CacheLoader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// CacheWeigher оценивает размер значения в байтах.
+type CacheWeigher[V any] func(value V) int64
+
+// CacheConfig содержит параметры кэша.
+type CacheConfig struct {
+	Size     int           // Максимальное количество элементов, 0 - без ограничения при заданном MaxBytes
+	MaxBytes int64         // Максимальный суммарный размер элементов в байтах, 0 - без ограничения
+	Policy   string        // Политика вытеснения
+	TTL      time.Duration // Срок жизни элемента для политики ttl
+
+	SnapshotPath   string        // Файл снимка кэша, пустая строка - снимок не используется
+	SnapshotMaxAge time.Duration // Максимальный возраст снимка, при котором он загружается
+}
+
 // Cache представляет структуру для кэширования данных.
-type Cache struct {
-	buffer  map[string]interface{} // Буфер кэша
-	queue   []string               // Очередь элементов кэша
-	bufSize int                    // Размер кэша
-	pos     int                    // Текущая позиция в очереди
-	DBInst  *DB                    // Экземпляр базы данных
-	name    string                 // Имя кэша
-	mutex   *sync.RWMutex          // Мьютекс для синхронизации доступа к кэшу
-}
-
-// NewCache создает новый экземпляр кэша.
-func NewCache(db *DB) *Cache {
-	csh := Cache{
-		DBInst: db,
-		name:   "Cache",
-		mutex:  &sync.RWMutex{},
-	}
-	csh.init()
-	return &csh
+// Порядок вытеснения элементов определяется политикой, выбранной через CACHE_POLICY.
+type Cache[K comparable, V any] struct {
+	buffer       map[K]V           // Буфер кэша
+	policy       evictionPolicy[K] // Политика вытеснения элементов
+	policyName   string            // Название политики вытеснения
+	bufSize      int               // Размер кэша
+	maxBytes     int64             // Бюджет кэша в байтах
+	bytes        int64             // Текущий суммарный размер элементов в байтах
+	weigher      CacheWeigher[V]   // Оценщик размера значений
+	snapshot     string            // Файл снимка кэша
+	maxAge       time.Duration     // Максимальный возраст снимка
+	fromSnapshot bool              // Загружено ли содержимое кэша из снимка
+	store        CacheStore[K, V]  // Хранилище состава кэша
+	loader       CacheLoader[K, V] // Загрузчик значений при промахе
+	name         string            // Имя кэша
+	mutex        *sync.RWMutex     // Мьютекс для синхронизации доступа к кэшу
+	loads        flightGroup[K, V] // Группа одновременных загрузок
+	generation   uint64            // Увеличивается при каждом удалении и обновлении любого элемента, защищено mutex
+	hits         atomic.Uint64     // Количество попаданий в кэш
+	misses       atomic.Uint64     // Количество промахов кэша
+	evictions    atomic.Uint64     // Количество вытесненных и устаревших элементов
 }
 
-// init выполняет инициализацию кэша.
-func (c *Cache) init() {
-	db := c.DBInst
-	db.SetCacheInstance(c)
-	c.bufSize = c.getCacheSize()
-	c.buffer = make(map[string]interface{}, c.bufSize)
-	c.queue = make([]string, c.bufSize)
-	c.pos = 0
-	c.restoreFromDatabase()
+// CacheStats содержит счетчики работы кэша.
+type CacheStats struct {
+	Policy    string `json:"policy"`
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
 }
 
-// getCacheSize получает размер кэша из переменных окружения.
-func (c *Cache) getCacheSize() int {
+// NewCacheOf создает новый экземпляр кэша и загружает его содержимое из файла снимка cfg.SnapshotPath.
+// Если снимок не загружен, содержимое восстанавливается из store вызовом Restore.
+// weigher используется для учета размера элементов, если задан cfg.MaxBytes.
+func NewCacheOf[K comparable, V any](name string, cfg CacheConfig, store CacheStore[K, V], loader CacheLoader[K, V], weigher CacheWeigher[V]) *Cache[K, V] {
+	// С отрицательными ограничениями кэш рос бы без ограничения
+	if cfg.Size < 0 {
+		log.Printf("%s: Предупреждение: Отрицательный размер кэша %d, установлен размер кэша по умолчанию - 10\n", name, cfg.Size)
+		cfg.Size = 10
+	}
+	if cfg.MaxBytes < 0 {
+		log.Printf("%s: Предупреждение: Отрицательный бюджет кэша %d байт, бюджет в байтах не учитывается\n", name, cfg.MaxBytes)
+		cfg.MaxBytes = 0
+	}
+	csh := Cache[K, V]{
+		bufSize:  cfg.Size,
+		maxBytes: cfg.MaxBytes,
+		buffer:   make(map[K]V, cfg.Size),
+		store:    store,
+		loader:   loader,
+		weigher:  weigher,
+		snapshot: cfg.SnapshotPath,
+		maxAge:   cfg.SnapshotMaxAge,
+		name:     name,
+		mutex:    &sync.RWMutex{},
+	}
+	if csh.maxBytes > 0 && csh.weigher == nil {
+		log.Printf("%s: Предупреждение: размер элементов не оценивается, бюджет в байтах не учитывается\n", name)
+		csh.maxBytes = 0
+	}
+	csh.policyName, csh.policy = newCachePolicy[K](name, cfg)
+	csh.fromSnapshot = csh.restoreSnapshot()
+	return &csh
+}
+
+// CacheConfigFromEnv получает параметры кэша из переменных окружения.
+// Отрицательные CACHE_SIZE и CACHE_MAX_BYTES отклоняются: с ними кэш рос бы без ограничения.
+func CacheConfigFromEnv(name string) CacheConfig {
 	bufSize, err := strconv.Atoi(os.Getenv("CACHE_SIZE"))
-	if err != nil {
-		log.Printf("%s: Предупреждение: Установлен размер кэша по умолчанию - 10\n", c.name)
+	if err != nil || bufSize < 0 {
+		log.Printf("%s: Предупреждение: Некорректный CACHE_SIZE %q, установлен размер кэша по умолчанию - 10\n", name, os.Getenv("CACHE_SIZE"))
 		bufSize = 10
 	}
-	return bufSize
+
+	maxBytes, err := strconv.ParseInt(os.Getenv("CACHE_MAX_BYTES"), 10, 64)
+	if err != nil || maxBytes < 0 {
+		if value := os.Getenv("CACHE_MAX_BYTES"); value != "" {
+			log.Printf("%s: Предупреждение: Некорректный CACHE_MAX_BYTES %q, бюджет в байтах не учитывается\n", name, value)
+		}
+		maxBytes = 0
+	}
+
+	ttl, _ := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	maxAge, _ := time.ParseDuration(os.Getenv("CACHE_SNAPSHOT_MAX_AGE"))
+
+	return CacheConfig{
+		Size:           bufSize,
+		MaxBytes:       maxBytes,
+		Policy:         strings.ToLower(os.Getenv("CACHE_POLICY")),
+		TTL:            ttl,
+		SnapshotPath:   os.Getenv("CACHE_SNAPSHOT_PATH"),
+		SnapshotMaxAge: maxAge,
+	}
+}
+
+// newCachePolicy создает политику вытеснения по параметрам кэша.
+func newCachePolicy[K comparable](name string, cfg CacheConfig) (string, evictionPolicy[K]) {
+	policy, err := newEvictionPolicy[K](cfg.Policy, cfg.TTL)
+	if err != nil {
+		log.Printf("%s: Предупреждение: %v, установлена политика по умолчанию - %s\n", name, err, PolicyFIFO)
+		return PolicyFIFO, newOrderedPolicy[K](false)
+	}
+	if cfg.Policy == "" {
+		return PolicyFIFO, policy
+	}
+	return cfg.Policy, policy
 }
 
-// restoreFromDatabase восстанавливает данные кэша из базы данных.
-func (c *Cache) restoreFromDatabase() {
-	log.Printf("%s: Проверка и загрузка кэша из базы данных\n", c.name)
-	buf, queue, pos, err := c.DBInst.GetCacheState(c.bufSize)
+// restoreSnapshot загружает данные кэша из файла снимка и сообщает, удалось ли это.
+func (c *Cache[K, V]) restoreSnapshot() bool {
+	if c.disabled() || c.snapshot == "" {
+		return false
+	}
+
+	log.Printf("%s: Проверка и загрузка снимка кэша\n", c.name)
+	started := time.Now()
+	queue, buf, err := c.restoreFromSnapshot()
 	if err != nil {
-		log.Printf("%s: Предупреждение: Не удалось загрузить из базы данных или кэш пуст: %v\n", c.name, err)
+		return false
+	}
+
+	restored := c.fill(queue, buf)
+	log.Printf("%s: Кэш загружен из снимка: восстановлено элементов: %d за %v", c.name, restored, time.Since(started))
+	return true
+}
+
+// Restore восстанавливает данные кэша из хранилища, если при создании кэша снимок не был загружен.
+// Хранилище может быть недоступно при создании кэша, поэтому восстановление из него
+// выполняется отдельно, когда хранилище готово. Восстановление прерывается вместе с ctx.
+func (c *Cache[K, V]) Restore(ctx context.Context) {
+	if c.disabled() || c.store == nil || c.fromSnapshot {
 		return
 	}
 
+	log.Printf("%s: Проверка и загрузка кэша\n", c.name)
+	started := time.Now()
+	queue, buf, err := c.store.Restore(ctx, c.bufSize)
+	if err != nil {
+		log.Printf("%s: Предупреждение: Не удалось загрузить кэш или кэш пуст: %v\n", c.name, err)
+		return
+	}
+
+	restored := c.fill(queue, buf)
+	log.Printf("%s: Кэш загружен: восстановлено элементов: %d за %v", c.name, restored, time.Since(started))
+}
+
+// fill помещает восстановленные элементы в кэш и возвращает количество оставшихся в нем.
+// Очередь идет от старых элементов к новым, поэтому новые элементы вытесняются последними.
+// Если сохраненные элементы не помещаются в бюджет, старые вытесняются сразу.
+func (c *Cache[K, V]) fill(queue []K, buf map[K]V) int {
+	var evicted []K
 	c.mutex.Lock()
-	copy(c.queue, queue)
-	c.pos = pos
-	c.buffer = buf
+	for _, key := range queue {
+		victims, _ := c.insert(key, buf[key], c.weigh(buf[key]))
+		evicted = append(evicted, victims...)
+	}
 	c.mutex.Unlock()
 
-	log.Printf("%s: Кэш загружен из базы данных: Очередь: %v, Следующая позиция в очереди: %v", c.name, c.queue, c.pos)
+	c.evicted(evicted)
+	return len(queue) - len(evicted)
+}
+
+// restoreFromSnapshot загружает элементы из файла снимка.
+// Загруженный снимок удаляется, чтобы после аварийного завершения не восстановить устаревшее состояние.
+func (c *Cache[K, V]) restoreFromSnapshot() ([]K, map[K]V, error) {
+	queue, buf, created, err := readSnapshot[K, V](c.snapshot, c.maxAge)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s: Предупреждение: снимок %s не загружен: %v\n", c.name, c.snapshot, err)
+		}
+		return nil, nil, err
+	}
+
+	if err := os.Remove(c.snapshot); err != nil {
+		log.Printf("%s: Предупреждение: не удалось удалить снимок %s: %v\n", c.name, c.snapshot, err)
+	}
+	log.Printf("%s: Загружен снимок кэша от %v\n", c.name, created.Format(time.RFC3339))
+	return queue, buf, nil
+}
+
+// disabled сообщает, отключен ли кэш конфигурацией.
+func (c *Cache[K, V]) disabled() bool {
+	return c.bufSize == 0 && c.maxBytes == 0
+}
+
+// weigh оценивает размер значения в байтах.
+func (c *Cache[K, V]) weigh(value V) int64 {
+	if c.weigher == nil {
+		return 0
+	}
+	return c.weigher(value)
 }
 
 // Set добавляет данные в кэш.
-func (c *Cache) Set(key string, value interface{}) {
-	if c.bufSize == 0 {
+// Если ключ уже есть в кэше, его значение обновляется на месте.
+// Если кэш заполнен по количеству элементов или по размеру в байтах, вытесняются элементы,
+// выбранные политикой, и хранилище уведомляется об этом.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.set(key, value, nil)
+}
+
+// set добавляет данные в кэш для Set. Если задан current, данные добавляются, только если
+// current под мьютексом подтверждает, что они не устарели.
+func (c *Cache[K, V]) set(key K, value V, current func() bool) {
+	if c.disabled() {
 		log.Printf("%s: Кэш отключен: bufSize = 0 (см. config.go)\n", c.name)
 		return
 	}
 
+	weight := c.weigh(value)
+	if c.maxBytes > 0 && weight > c.maxBytes {
+		// Элемент больше всего бюджета: не кэшируем его и убираем устаревшую версию
+		c.mutex.Lock()
+		if current != nil && !current() {
+			c.mutex.Unlock()
+			return
+		}
+		_, exists := c.buffer[key]
+		if exists {
+			c.remove(key)
+		}
+		c.mutex.Unlock()
+		if exists {
+			c.evicted([]K{key})
+		}
+		log.Printf("%s: Элемент %v (%d байт) превышает бюджет кэша %d байт\n", c.name, key, weight, c.maxBytes)
+		return
+	}
+
 	c.mutex.Lock()
-	c.queue[c.pos] = key
-	c.pos++
-	if c.pos == c.bufSize {
-		c.pos = 0
+	if current != nil && !current() {
+		c.mutex.Unlock()
+		log.Printf("%s: Элемент %v изменился во время загрузки, загруженные данные не кэшируются\n", c.name, key)
+		return
 	}
-	c.buffer[key] = value
+	evicted, exists := c.insert(key, value, weight)
 	c.mutex.Unlock()
 
-	c.DBInst.SendOrderIDToCache(key)
-	log.Printf("%s: Данные успешно добавлены в кэш, Позиция в очереди: %v\n", c.name, c.pos)
+	c.evicted(evicted)
+
+	if exists {
+		log.Printf("%s: Данные в кэше обновлены\n", c.name)
+		return
+	}
+
+	if c.store != nil {
+		c.store.Added(key)
+	}
+	log.Printf("%s: Данные успешно добавлены в кэш\n", c.name)
+}
+
+// insert добавляет или обновляет элемент, предварительно освобождая для него место.
+// Возвращает вытесненные ключи и признак того, что ключ уже был в кэше. Вызывается под мьютексом.
+func (c *Cache[K, V]) insert(key K, value V, weight int64) ([]K, bool) {
+	evicted := c.purgeExpired()
+
+	old, exists := c.buffer[key]
+	if exists {
+		c.bytes -= c.weigh(old)
+	}
+
+	// Освобождаем место до вставки, чтобы новый элемент не стал кандидатом на вытеснение.
+	// Обновляемый ключ не вытесняется, даже если политика выбрала его первым.
+	for c.overflows(exists, weight) {
+		victim, ok := c.victimExcept(key)
+		if !ok {
+			break
+		}
+		c.remove(victim)
+		evicted = append(evicted, victim)
+	}
+
+	c.buffer[key] = value
+	c.bytes += weight
+	c.policy.add(key)
+	return evicted, exists
+}
+
+// victimExcept возвращает первого кандидата на вытеснение, отличного от key. Вызывается под мьютексом.
+func (c *Cache[K, V]) victimExcept(key K) (K, bool) {
+	victim, ok := c.policy.victim()
+	if !ok || victim != key {
+		return victim, ok
+	}
+	for _, candidate := range c.policy.keys() {
+		if candidate != key {
+			return candidate, true
+		}
+	}
+	var zero K
+	return zero, false
+}
+
+// overflows сообщает, нужно ли освободить место для элемента размером weight. Вызывается под мьютексом.
+func (c *Cache[K, V]) overflows(exists bool, weight int64) bool {
+	if !exists && c.bufSize > 0 && len(c.buffer) >= c.bufSize {
+		return true
+	}
+	return c.maxBytes > 0 && c.bytes+weight > c.maxBytes
+}
+
+// evicted учитывает вытесненные ключи и уведомляет о них хранилище.
+func (c *Cache[K, V]) evicted(keys []K) {
+	c.evictions.Add(uint64(len(keys)))
+	for _, key := range keys {
+		if c.store != nil {
+			c.store.Evicted(key)
+		}
+		log.Printf("%s: Элемент %v вытеснен из кэша\n", c.name, key)
+	}
 }
 
 // Get получает данные из кэша по ключу.
-func (c *Cache) Get(key string) (interface{}, bool) {
+// Устаревший элемент считается промахом и удаляется из кэша.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	data, exists := c.buffer[key]
+	expired := exists && c.policy.expired(key)
+	if expired {
+		c.remove(key)
+		exists = false
+	} else if exists {
+		c.policy.access(key)
+	}
+	c.mutex.Unlock()
+
+	if expired {
+		c.evicted([]K{key})
+	}
+	if !exists {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	c.hits.Add(1)
+	return data, true
+}
+
+// Peek получает данные из кэша без учета в статистике и политике вытеснения.
+// Устаревший элемент считается отсутствующим, но из кэша не удаляется.
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
 	c.mutex.RLock()
 	data, exists := c.buffer[key]
+	if exists && c.policy.expired(key) {
+		exists = false
+	}
 	c.mutex.RUnlock()
 	return data, exists
 }
 
-// Finish завершает работу кэша и очищает его содержимое в базе данных.
-func (c *Cache) Finish() {
+// remove удаляет элемент из кэша. Вызывается под мьютексом.
+func (c *Cache[K, V]) remove(key K) {
+	c.bytes -= c.weigh(c.buffer[key])
+	delete(c.buffer, key)
+	c.policy.remove(key)
+}
+
+// purgeExpired удаляет устаревшие элементы из начала очереди вытеснения. Вызывается под мьютексом.
+func (c *Cache[K, V]) purgeExpired() []K {
+	var expired []K
+	for {
+		victim, ok := c.policy.victim()
+		if !ok || !c.policy.expired(victim) {
+			return expired
+		}
+		c.remove(victim)
+		expired = append(expired, victim)
+	}
+}
+
+// Stats возвращает текущие счетчики кэша.
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mutex.RLock()
+	size := len(c.buffer)
+	bytes := c.bytes
+	c.mutex.RUnlock()
+
+	return CacheStats{
+		Policy:    c.policyName,
+		Size:      size,
+		Capacity:  c.bufSize,
+		Bytes:     bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// Load загружает значение через загрузчик кэша и помещает его в кэш.
+// Одновременные промахи по одному и тому же ключу объединяются в одну загрузку.
+// Если ctx завершится раньше загрузки, Load возвращает ошибку ctx, не дожидаясь её.
+// Если во время загрузки элемент был удален или обновлен (Delete, Refresh), загруженное значение
+// могло устареть, поэтому оно возвращается, но не помещается в кэш.
+func (c *Cache[K, V]) Load(ctx context.Context, key K) (V, error) {
+	return c.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		generation := c.currentGeneration()
+
+		// Пока мы ждали, значение могло быть загружено другим запросом
+		if data, exists := c.Peek(key); exists {
+			return data, nil
+		}
+
+		data, err := c.loader(ctx, key)
+		if err != nil {
+			return data, err
+		}
+
+		c.set(key, data, func() bool { return c.generation == generation })
+		return data, nil
+	})
+}
+
+// currentGeneration возвращает номер последнего удаления или обновления элемента кэша.
+func (c *Cache[K, V]) currentGeneration() uint64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.generation
+}
+
+// Delete удаляет элемент из кэша и уведомляет об этом хранилище.
+// Возвращает false, если элемента в кэше не было.
+func (c *Cache[K, V]) Delete(key K) bool {
+	c.mutex.Lock()
+	// Загрузки, начатые до удаления, не должны вернуть элемент в кэш
+	c.generation++
+	_, exists := c.buffer[key]
+	if exists {
+		c.remove(key)
+	}
+	c.mutex.Unlock()
+
+	if exists {
+		if c.store != nil {
+			c.store.Evicted(key)
+		}
+		log.Printf("%s: Элемент %v удален из кэша\n", c.name, key)
+	}
+	return exists
+}
+
+// Refresh заново загружает элемент через загрузчик кэша, если он есть в кэше.
+// Возвращает false, если элемента в кэше не было и загрузка не выполнялась.
+// Загрузки Load и Refresh, начатые раньше, не перезапишут обновленный элемент устаревшим значением.
+// Если во время загрузки элемент кэша был удален или обновлен, результат мог устареть: он не помещается
+// в кэш, а элемент удаляется, чтобы не отдавать устаревшую версию.
+func (c *Cache[K, V]) Refresh(ctx context.Context, key K) (bool, error) {
+	c.mutex.Lock()
+	_, exists := c.buffer[key]
+	if exists {
+		c.generation++
+	}
+	generation := c.generation
+	c.mutex.Unlock()
+	if !exists {
+		return false, nil
+	}
+
+	data, err := c.loader(ctx, key)
+	if err != nil {
+		return true, err
+	}
+	current := true
+	c.set(key, data, func() bool {
+		current = c.generation == generation
+		return current
+	})
+	if !current {
+		c.Delete(key)
+	}
+	return true, nil
+}
+
+// Finish завершает работу кэша и сохраняет его содержимое в файл снимка.
+// Состав кэша в хранилище сохраняется, поэтому при отсутствии снимка кэш будет восстановлен из него.
+// Если ctx уже завершен, снимок не сохраняется.
+func (c *Cache[K, V]) Finish(ctx context.Context) error {
 	log.Printf("%s: Завершение работы...", c.name)
-	c.DBInst.ClearCache()
+	if c.snapshot != "" {
+		if err := ctx.Err(); err != nil {
+			log.Printf("%s: Снимок кэша не сохранен: %v\n", c.name, err)
+			return err
+		}
+
+		c.mutex.RLock()
+		keys := c.policy.keys()
+		err := writeSnapshot(c.snapshot, keys, c.buffer)
+		c.mutex.RUnlock()
+
+		if err != nil {
+			log.Printf("%s: Не удалось сохранить снимок кэша: %v\n", c.name, err)
+			return err
+		}
+		log.Printf("%s: Снимок кэша сохранен в %s, элементов: %d\n", c.name, c.snapshot, len(keys))
+	}
 	log.Printf("%s: Завершено", c.name)
+	return nil
 }
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain отключает журнал кэша, который пишет строку на каждую операцию.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestCache создает кэш без хранилища и снимка, размер строки равен ее длине.
+func newTestCache(t *testing.T, cfg CacheConfig) *Cache[string, string] {
+	t.Helper()
+	return NewCacheOf[string, string]("test", cfg, nil, nil, func(v string) int64 { return int64(len(v)) })
+}
+
+func bytesOf(n int) string {
+	return string(make([]byte, n))
+}
+
+// Обновление ключа, который политика выбрала бы первым, не должно выводить кэш за бюджет.
+func TestCacheUpdateVictimKeepsByteBudget(t *testing.T) {
+	for _, policy := range []string{PolicyFIFO, PolicyLRU, PolicyLFU} {
+		t.Run(policy, func(t *testing.T) {
+			csh := newTestCache(t, CacheConfig{MaxBytes: 100, Policy: policy})
+
+			csh.Set("A", bytesOf(40))
+			csh.Set("B", bytesOf(50))
+			csh.Set("A", bytesOf(55))
+
+			stats := csh.Stats()
+			if stats.Bytes > stats.MaxBytes {
+				t.Fatalf("Bytes = %d, превышает MaxBytes = %d", stats.Bytes, stats.MaxBytes)
+			}
+			if got, ok := csh.Get("A"); !ok || len(got) != 55 {
+				t.Fatalf("Get(A) = %d байт, %v; ожидалась новая версия из 55 байт", len(got), ok)
+			}
+			if _, ok := csh.Get("B"); ok {
+				t.Fatalf("B должен быть вытеснен, чтобы освободить место для новой версии A")
+			}
+			if stats.Bytes != 55 {
+				t.Fatalf("Bytes = %d, ожидалось 55", stats.Bytes)
+			}
+		})
+	}
+}
+
+// checkInvariants проверяет, что кэш не выходит за ограничения и его учет согласован с содержимым.
+func checkInvariants[K comparable, V any](t *testing.T, csh *Cache[K, V]) {
+	t.Helper()
+
+	if csh.bufSize > 0 && len(csh.buffer) > csh.bufSize {
+		t.Fatalf("len(buffer) = %d, превышает bufSize = %d", len(csh.buffer), csh.bufSize)
+	}
+	if csh.maxBytes > 0 && csh.bytes > csh.maxBytes {
+		t.Fatalf("bytes = %d, превышает maxBytes = %d", csh.bytes, csh.maxBytes)
+	}
+
+	var total int64
+	for _, value := range csh.buffer {
+		total += csh.weigh(value)
+	}
+	if total != csh.bytes {
+		t.Fatalf("bytes = %d, сумма размеров элементов = %d", csh.bytes, total)
+	}
+
+	keys := csh.policy.keys()
+	if len(keys) != len(csh.buffer) {
+		t.Fatalf("в политике %d ключей, в буфере %d", len(keys), len(csh.buffer))
+	}
+	for _, key := range keys {
+		if _, ok := csh.buffer[key]; !ok {
+			t.Fatalf("ключ %v есть в политике, но отсутствует в буфере", key)
+		}
+	}
+}
+
+// Случайная последовательность Set, Get и Delete не выводит кэш за bufSize и maxBytes ни для одной политики.
+func TestCacheRandomOperationsKeepLimits(t *testing.T) {
+	configs := []CacheConfig{
+		{Size: 10},
+		{MaxBytes: 500},
+		{Size: 7, MaxBytes: 300},
+		{Size: 1, MaxBytes: 64},
+	}
+	policies := []string{PolicyFIFO, PolicyLRU, PolicyLFU, PolicyTTL}
+
+	for _, policy := range policies {
+		for _, cfg := range configs {
+			cfg.Policy = policy
+			cfg.TTL = time.Hour
+			name := fmt.Sprintf("%s/size=%d/bytes=%d", policy, cfg.Size, cfg.MaxBytes)
+
+			t.Run(name, func(t *testing.T) {
+				// Зерно выводится в журнал теста, чтобы падение можно было воспроизвести
+				seed := time.Now().UnixNano()
+				t.Logf("seed = %d", seed)
+				rnd := rand.New(rand.NewSource(seed))
+				csh := newTestCache(t, cfg)
+
+				for i := 0; i < 5000; i++ {
+					key := strconv.Itoa(rnd.Intn(30))
+					switch op := rnd.Intn(10); {
+					case op < 6:
+						// Иногда элемент больше всего бюджета: такой элемент не кэшируется
+						csh.Set(key, bytesOf(rnd.Intn(120)))
+					case op < 9:
+						csh.Get(key)
+					default:
+						csh.Delete(key)
+					}
+					checkInvariants(t, csh)
+				}
+			})
+		}
+	}
+}
+
+// blockingLoader возвращает загрузчик, первый вызов которого читает first и ждет закрытия gate,
+// а остальные сразу возвращают next. started закрывается, когда первый вызов прочитал значение.
+func blockingLoader(first, next string, started, gate chan struct{}) CacheLoader[string, string] {
+	var calls atomic.Int32
+	return func(ctx context.Context, key string) (string, error) {
+		if calls.Add(1) > 1 {
+			return next, nil
+		}
+		close(started)
+		<-gate
+		return first, nil
+	}
+}
+
+// Загрузка, начатая до Refresh, не перезаписывает обновленный элемент прочитанной раньше версией.
+func TestLoadDoesNotOverwriteRefresh(t *testing.T) {
+	started, gate := make(chan struct{}), make(chan struct{})
+	csh := NewCacheOf[string, string]("test", CacheConfig{Size: 10}, nil, blockingLoader("v1", "v2", started, gate),
+		func(v string) int64 { return int64(len(v)) })
+
+	loaded := make(chan string)
+	go func() {
+		value, _ := csh.Load(context.Background(), "A")
+		loaded <- value
+	}()
+	<-started
+
+	csh.Set("A", "v1")
+	if refreshed, err := csh.Refresh(context.Background(), "A"); !refreshed || err != nil {
+		t.Fatalf("Refresh = %v, %v; ожидается обновление элемента", refreshed, err)
+	}
+	close(gate)
+	if value := <-loaded; value != "v1" {
+		t.Fatalf("Load вернул %q, ожидается прочитанное им значение v1", value)
+	}
+
+	if value, _ := csh.Peek("A"); value != "v2" {
+		t.Fatalf("в кэше %q, ожидается обновленная версия v2", value)
+	}
+}
+
+// Загрузка, начатая до Delete, не возвращает удаленный элемент в кэш.
+func TestLoadDoesNotRestoreDeleted(t *testing.T) {
+	started, gate := make(chan struct{}), make(chan struct{})
+	csh := NewCacheOf[string, string]("test", CacheConfig{Size: 10}, nil, blockingLoader("v1", "v1", started, gate),
+		func(v string) int64 { return int64(len(v)) })
+
+	loaded := make(chan error)
+	go func() {
+		_, err := csh.Load(context.Background(), "A")
+		loaded <- err
+	}()
+	<-started
+
+	csh.Delete("A")
+	close(gate)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := csh.Peek("A"); ok {
+		t.Fatal("удаленный во время загрузки элемент не должен попасть в кэш")
+	}
+}
+
+// Отрицательные ограничения кэша отклоняются, и кэш остается ограниченным.
+func TestCacheRejectsNegativeLimits(t *testing.T) {
+	t.Setenv("CACHE_SIZE", "-5")
+	t.Setenv("CACHE_MAX_BYTES", "-100")
+	cfg := CacheConfigFromEnv("test")
+	if cfg.Size != 10 || cfg.MaxBytes != 0 {
+		t.Fatalf("Size = %d, MaxBytes = %d; ожидается размер по умолчанию 10 и бюджет 0", cfg.Size, cfg.MaxBytes)
+	}
+
+	csh := newTestCache(t, CacheConfig{Size: -1, MaxBytes: -1})
+	for i := 0; i < 100; i++ {
+		csh.Set(strconv.Itoa(i), "v")
+	}
+	if stats := csh.Stats(); stats.Size != 10 || stats.Capacity != 10 || stats.MaxBytes != 0 {
+		t.Fatalf("size %d, capacity %d, max bytes %d; ожидается 10, 10 и 0", stats.Size, stats.Capacity, stats.MaxBytes)
+	}
+}
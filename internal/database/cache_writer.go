@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// cacheOp описывает изменение состава кеша в таблице wb_scheme.cache.
+type cacheOp struct {
+	oid    string
+	remove bool // true - удалить запись, false - добавить
+}
+
+// CacheWriterStats содержит счетчики фоновой записи состава кеша.
+// Retries - неудачные попытки записи пачки, после которых запись повторяется,
+// Failed - изменения, которые не удалось записать до завершения работы,
+// Dropped - изменения, отброшенные из-за заполненной очереди.
+type CacheWriterStats struct {
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Retries uint64 `json:"retries"`
+	Failed  uint64 `json:"failed"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// cacheWriter в фоне записывает изменения состава кеша в wb_scheme.cache пачками.
+// Пачка записывается, когда набирается batchSize изменений или проходит interval.
+// Пачку, которую не удалось записать, writer повторяет с растущей паузой, не больше retryMax;
+// до успешной записи новые изменения копятся в очереди. Если очередь заполнена
+// (PostgreSQL не успевает или недоступен), новые изменения отбрасываются,
+// чтобы не задерживать работу кеша.
+type cacheWriter struct {
+	db        *DB
+	write     func(ctx context.Context, batch []cacheOp) error // Запись пачки изменений
+	ops       chan cacheOp
+	batchSize int
+	interval  time.Duration
+	retryMax  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+	written   atomic.Uint64
+	retries   atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+
+	// ctx передается в запись пачек и отменяется, если завершение работы не уложилось в срок
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newCacheWriter создает и запускает фоновую запись состава кеша в базу данных db.
+func newCacheWriter(db *DB) *cacheWriter {
+	return startCacheWriter(db, db.writeCacheOps)
+}
+
+// startCacheWriter создает и запускает фоновую запись состава кеша, которая записывает пачки через write.
+func startCacheWriter(db *DB, write func(ctx context.Context, batch []cacheOp) error) *cacheWriter {
+	w := &cacheWriter{
+		db:        db,
+		write:     write,
+		ops:       make(chan cacheOp, envInt("CACHE_WRITER_QUEUE", 1000)),
+		batchSize: envInt("CACHE_WRITER_BATCH_SIZE", 100),
+		interval:  envDuration("CACHE_WRITER_INTERVAL", time.Second),
+		retryMax:  envDuration("CACHE_WRITER_RETRY_MAX", 30*time.Second),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+	return w
+}
+
+// enqueue ставит изменение в очередь на запись. Если очередь заполнена, ждет
+// освобождения места, пока не завершится ctx, после чего изменение отбрасывается.
+func (w *cacheWriter) enqueue(ctx context.Context, op cacheOp) {
+	select {
+	case w.ops <- op:
+		return
+	default:
+	}
+
+	select {
+	case w.ops <- op:
+	case <-ctx.Done():
+		w.dropped.Add(1)
+		log.Printf("%v: очередь записи кеша переполнена, изменение для %s отброшено\n", w.db.name, op.oid)
+	}
+}
+
+// run накапливает изменения и записывает их пачками.
+func (w *cacheWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]cacheOp, 0, w.batchSize)
+	var backoff time.Duration  // Пауза перед повторной записью, 0 - пачка не ждет повтора
+	var retry <-chan time.Time // Срабатывает, когда пора повторить запись
+	flush := func() {
+		if w.flush(batch) {
+			batch, backoff, retry = batch[:0], 0, nil
+			return
+		}
+		backoff = min(max(2*backoff, w.interval), w.retryMax)
+		retry = time.After(backoff)
+	}
+
+	for {
+		// Пока пачка ждет повторной записи, новые изменения остаются в очереди
+		ops := w.ops
+		if retry != nil {
+			ops = nil
+		}
+
+		select {
+		case op := <-ops:
+			batch = append(batch, op)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			if retry == nil {
+				flush()
+			}
+		case <-retry:
+			flush()
+		case <-w.stop:
+			// Записываем все, что успело попасть в очередь
+			for {
+				select {
+				case op := <-w.ops:
+					batch = append(batch, op)
+				default:
+					if !w.flush(batch) {
+						w.failed.Add(uint64(len(batch)))
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush записывает пачку изменений не дольше DB_WRITE_TIMEOUT и сообщает, удалась ли запись.
+func (w *cacheWriter) flush(batch []cacheOp) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	ctx, cancel := withTimeout(w.ctx, w.db.timeouts.Write)
+	defer cancel()
+
+	if err := w.write(ctx, batch); err != nil {
+		w.retries.Add(1)
+		log.Printf("%v: не удалось записать состав кеша (%d изменений): %v\n", w.db.name, len(batch), err)
+		return false
+	}
+
+	w.written.Add(uint64(len(batch)))
+	return true
+}
+
+// writeCacheOps записывает пачку изменений состава кеша в одной транзакции.
+// Подряд идущие добавления и удаления объединяются в один запрос, порядок изменений сохраняется.
+func (db *DB) writeCacheOps(ctx context.Context, batch []cacheOp) error {
+	appKey := os.Getenv("APP_KEY")
+
+	tx, err := db.sqlDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].remove == batch[start].remove {
+			end++
+		}
+
+		oids := make([]string, 0, end-start)
+		for _, op := range batch[start:end] {
+			oids = append(oids, op.oid)
+		}
+
+		if batch[start].remove {
+			_, err = tx.ExecContext(ctx, `DELETE FROM wb_scheme.cache WHERE app_key = $1 AND order_uid = ANY($2)`, appKey, pq.Array(oids))
+		} else {
+			err = insertCacheRows(ctx, tx, appKey, oids)
+		}
+		if err != nil {
+			return err
+		}
+
+		start = end
+	}
+
+	return tx.Commit()
+}
+
+// insertCacheRows добавляет записи состава кеша одним многострочным INSERT.
+// Уже записанные заказы пропускаются.
+func insertCacheRows(ctx context.Context, tx *sql.Tx, appKey string, oids []string) error {
+	values := make([]string, 0, len(oids))
+	args := make([]any, 0, len(oids)+1)
+	args = append(args, appKey)
+	for i, oid := range oids {
+		values = append(values, fmt.Sprintf("($%d, $1)", i+2))
+		args = append(args, oid)
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO wb_scheme.cache (order_uid, app_key) VALUES `+strings.Join(values, ", ")+
+		` ON CONFLICT (app_key, order_uid) DO NOTHING`, args...)
+	return err
+}
+
+// close прекращает прием изменений и дожидается записи накопленных.
+// Если ctx завершится раньше, запись прерывается, а несохраненные изменения учитываются в Failed.
+func (w *cacheWriter) close(ctx context.Context) error {
+	close(w.stop)
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+		return ctx.Err()
+	}
+}
+
+// stats возвращает счетчики фоновой записи.
+func (w *cacheWriter) stats() CacheWriterStats {
+	return CacheWriterStats{
+		Queued:  len(w.ops),
+		Written: w.written.Load(),
+		Retries: w.retries.Load(),
+		Failed:  w.failed.Load(),
+		Dropped: w.dropped.Load(),
+	}
+}
+
+// envInt получает положительное целое число из переменной окружения или значение по умолчанию.
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// envDuration получает положительную длительность из переменной окружения или значение по умолчанию.
+func envDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingWriter запоминает записанные пачки, а первые failures попыток завершает ошибкой.
+// Если задан gate, запись ждет его закрытия.
+type recordingWriter struct {
+	mutex    sync.Mutex
+	failures int
+	attempts []time.Time
+	written  []cacheOp
+	gate     chan struct{}
+	started  chan struct{} // Получает сигнал при каждой попытке записи
+}
+
+func newRecordingWriter(failures int) *recordingWriter {
+	return &recordingWriter{failures: failures, started: make(chan struct{}, 100)}
+}
+
+func (r *recordingWriter) write(ctx context.Context, batch []cacheOp) error {
+	r.mutex.Lock()
+	r.attempts = append(r.attempts, time.Now())
+	fail := r.failures > 0
+	if fail {
+		r.failures--
+	}
+	r.mutex.Unlock()
+	r.started <- struct{}{}
+
+	if fail {
+		return errors.New("соединение потеряно")
+	}
+	if r.gate != nil {
+		select {
+		case <-r.gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	r.mutex.Lock()
+	r.written = append(r.written, batch...)
+	r.mutex.Unlock()
+	return nil
+}
+
+// Written возвращает order_uid записанных изменений в порядке записи.
+func (r *recordingWriter) Written() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	oids := make([]string, 0, len(r.written))
+	for _, op := range r.written {
+		oids = append(oids, op.oid)
+	}
+	return oids
+}
+
+// Attempts возвращает время каждой попытки записи.
+func (r *recordingWriter) Attempts() []time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]time.Time(nil), r.attempts...)
+}
+
+// newTestCacheWriter запускает фоновую запись через r и останавливает ее по завершении теста.
+func newTestCacheWriter(t *testing.T, r *recordingWriter) *cacheWriter {
+	t.Helper()
+	w := startCacheWriter(&DB{name: "test", timeouts: Timeouts{Write: 5 * time.Second}}, r.write)
+	t.Cleanup(func() {
+		select {
+		case <-w.stop:
+		default:
+			w.close(context.Background())
+		}
+	})
+	return w
+}
+
+// waitFor ждет выполнения условия не дольше timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func equalOids(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Пачка, которую не удалось записать, повторяется с удваивающейся паузой, а новые изменения ждут ее записи.
+func TestCacheWriterRetriesWithBackoff(t *testing.T) {
+	t.Setenv("CACHE_WRITER_BATCH_SIZE", "1")
+	t.Setenv("CACHE_WRITER_INTERVAL", "20ms")
+	t.Setenv("CACHE_WRITER_RETRY_MAX", "1s")
+	r := newRecordingWriter(2)
+	w := newTestCacheWriter(t, r)
+
+	w.enqueue(context.Background(), cacheOp{oid: "A"})
+	<-r.started
+	w.enqueue(context.Background(), cacheOp{oid: "B", remove: true})
+
+	waitFor(t, 5*time.Second, "запись обеих пачек", func() bool { return len(r.Written()) == 2 })
+
+	if got := r.Written(); !equalOids(got, []string{"A", "B"}) {
+		t.Fatalf("записаны %v, ожидается [A B]: новые изменения не должны опережать повторяемую пачку", got)
+	}
+	attempts := r.Attempts()
+	if len(attempts) != 4 {
+		t.Fatalf("попыток записи: %d, ожидается 4 (две неудачные и две успешные)", len(attempts))
+	}
+	// Пауза начинается с CACHE_WRITER_INTERVAL и удваивается после каждой неудачи
+	if first := attempts[1].Sub(attempts[0]); first < 20*time.Millisecond {
+		t.Fatalf("первый повтор через %v, ожидается не раньше 20ms", first)
+	}
+	if second := attempts[2].Sub(attempts[1]); second < 40*time.Millisecond {
+		t.Fatalf("второй повтор через %v, ожидается не раньше 40ms", second)
+	}
+
+	stats := w.stats()
+	if stats.Retries != 2 || stats.Written != 2 || stats.Failed != 0 || stats.Dropped != 0 {
+		t.Fatalf("счетчики %+v, ожидается retries 2, written 2", stats)
+	}
+}
+
+// Если очередь заполнена, новое изменение отбрасывается и учитывается в Dropped.
+func TestCacheWriterDropsWhenQueueFull(t *testing.T) {
+	t.Setenv("CACHE_WRITER_BATCH_SIZE", "1")
+	t.Setenv("CACHE_WRITER_QUEUE", "2")
+	r := newRecordingWriter(0)
+	r.gate = make(chan struct{})
+	w := newTestCacheWriter(t, r)
+
+	// Первое изменение забирается из очереди и ждет записи, следующие два заполняют очередь
+	w.enqueue(context.Background(), cacheOp{oid: "A"})
+	<-r.started
+	w.enqueue(context.Background(), cacheOp{oid: "B"})
+	w.enqueue(context.Background(), cacheOp{oid: "C"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w.enqueue(ctx, cacheOp{oid: "D"})
+
+	stats := w.stats()
+	if stats.Dropped != 1 || stats.Queued != 2 {
+		t.Fatalf("dropped %d, queued %d; ожидается 1 и 2", stats.Dropped, stats.Queued)
+	}
+
+	close(r.gate)
+	if err := w.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Written(); !equalOids(got, []string{"A", "B", "C"}) {
+		t.Fatalf("записаны %v, ожидается [A B C]", got)
+	}
+}
+
+// При завершении работы записываются все изменения, которые успели попасть в очередь.
+func TestCacheWriterFlushesOnClose(t *testing.T) {
+	t.Setenv("CACHE_WRITER_BATCH_SIZE", "100")
+	t.Setenv("CACHE_WRITER_INTERVAL", "1h")
+	r := newRecordingWriter(0)
+	w := newTestCacheWriter(t, r)
+
+	want := []string{"A", "B", "C", "D", "E"}
+	for _, oid := range want {
+		w.enqueue(context.Background(), cacheOp{oid: oid})
+	}
+	if got := r.Written(); len(got) != 0 {
+		t.Fatalf("до завершения работы записаны %v, пачка не должна записываться раньше CACHE_WRITER_INTERVAL", got)
+	}
+
+	if err := w.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Written(); !equalOids(got, want) {
+		t.Fatalf("записаны %v, ожидается %v", got, want)
+	}
+	if stats := w.stats(); stats.Written != uint64(len(want)) || stats.Failed != 0 {
+		t.Fatalf("счетчики %+v, ожидается written %d, failed 0", stats, len(want))
+	}
+}
+
+// Если запись не уложилась в срок завершения работы, она прерывается, а изменения учитываются в Failed.
+func TestCacheWriterCloseRespectsDeadline(t *testing.T) {
+	t.Setenv("CACHE_WRITER_BATCH_SIZE", "100")
+	t.Setenv("CACHE_WRITER_INTERVAL", "1h")
+	r := newRecordingWriter(0)
+	r.gate = make(chan struct{})
+	w := newTestCacheWriter(t, r)
+
+	w.enqueue(context.Background(), cacheOp{oid: "A"})
+	w.enqueue(context.Background(), cacheOp{oid: "B"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close = %v, ожидается context.DeadlineExceeded", err)
+	}
+	if stats := w.stats(); stats.Failed != 2 || stats.Written != 0 {
+		t.Fatalf("счетчики %+v, ожидается failed 2, written 0", stats)
+	}
+}
@@ -1,29 +1,80 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
-// ConnectDB устанавливает соединение с базой данных.
-func (db *DB) ConnectDB() *sql.DB {
+// ConnectDB открывает пул соединений с базой данных.
+// Соединение устанавливается при первом запросе, поэтому доступность базы данных не проверяется.
+func (db *DB) ConnectDB() (*sql.DB, error) {
 	db.name = "postgres"
 	connStr := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s", os.Getenv("user"), os.Getenv("password"), os.Getenv("dbname"), os.Getenv("sslmode"))
 
-	var er error
+	return sql.Open(db.name, connStr)
+}
+
+// WaitReady дожидается готовности базы данных к работе сервиса: подключается к ней,
+// проверяет версию схемы и закрепляет APP_KEY. При неудаче попытка повторяется с удвоением
+// паузы, но не более DB_CONNECT_BACKOFF_MAX, пока не завершится ctx. Несовпадение версии схемы
+// не повторяется: WaitReady сразу возвращает ошибку ErrSchemaVersion.
+// До успешного завершения WaitReady хранилище PostgresRepository возвращает ErrUnavailable.
+func (db *DB) WaitReady(ctx context.Context) error {
+	backoffMax := envDuration("DB_CONNECT_BACKOFF_MAX", 30*time.Second)
+	backoff := 500 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err := db.prepare(ctx)
+		if err == nil {
+			db.ready.Store(true)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrSchemaVersion) {
+			return err
+		}
 
-	sqlDb, er := sql.Open(db.name, connStr)
-	if er != nil {
-		panic(er)
+		log.Printf("%v: база данных не готова (попытка %d), повтор через %v: %v\n", db.name, attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, backoffMax)
 	}
+}
 
-	er = sqlDb.Ping()
-	if er != nil {
-		panic(er)
+// prepare выполняет одну попытку подготовки базы данных для WaitReady.
+func (db *DB) prepare(ctx context.Context) error {
+	pingCtx, cancel := withTimeout(ctx, db.timeouts.Read)
+	defer cancel()
+	if err := db.sqlDb.PingContext(pingCtx); err != nil {
+		return err
 	}
 
-	return sqlDb
+	if err := db.CheckSchemaVersion(ctx); err != nil {
+		return err
+	}
+
+	// Блокировка могла остаться от предыдущей попытки
+	if db.appLock == nil {
+		if err := db.AcquireAppKey(ctx); err != nil {
+			return fmt.Errorf("не удалось закрепить APP_KEY: %w", err)
+		}
+	}
+	return nil
+}
+
+// Ready сообщает, завершилась ли подготовка базы данных в WaitReady.
+func (db *DB) Ready() bool {
+	return db.ready.Load()
 }
@@ -3,98 +3,263 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/lib/pq" // Импорт драйвера PostgreSQL
+	"github.com/lib/pq" // Драйвер PostgreSQL
 )
 
 // DB представляет собой объект базы данных.
 type DB struct {
-	name  string
-	sqlDb *sql.DB
-	csh   *Cache
+	name     string
+	sqlDb    *sql.DB
+	cacheW   *cacheWriter // Фоновая запись состава кеша
+	timeouts Timeouts
+	appLock  *sql.Conn   // Соединение, удерживающее блокировку APP_KEY
+	ready    atomic.Bool // Подключение проверено, схема подходит и APP_KEY закреплен
 }
 
-// NewDB создает новый экземпляр DB и устанавливает соединение с базой данных.
-func NewDB() (*DB, error) {
-	db := DB{}
-	db.sqlDb = db.ConnectDB()
-	return &db, nil
+// Timeouts задает предельное время операций с базой данных.
+// Ограничение применяется поверх контекста, переданного вызывающим.
+type Timeouts struct {
+	Read         time.Duration // Чтение заказов
+	Write        time.Duration // Сохранение заказа и запись состава кеша
+	Batch        time.Duration // Сохранение пачки заказов и восстановление кеша
+	CacheEnqueue time.Duration // Ожидание места в очереди записи состава кеша
+}
+
+// TimeoutsFromEnv получает предельное время операций из DB_READ_TIMEOUT, DB_WRITE_TIMEOUT,
+// DB_BATCH_TIMEOUT и CACHE_WRITER_ENQUEUE_WAIT.
+func TimeoutsFromEnv() Timeouts {
+	return Timeouts{
+		Read:         envDuration("DB_READ_TIMEOUT", 5*time.Second),
+		Write:        envDuration("DB_WRITE_TIMEOUT", 10*time.Second),
+		Batch:        envDuration("DB_BATCH_TIMEOUT", time.Minute),
+		CacheEnqueue: envDuration("CACHE_WRITER_ENQUEUE_WAIT", 0),
+	}
 }
 
-// SendOrderIDToCache добавляет информацию о заказе в кеш базы данных.
-func (db *DB) SendOrderIDToCache(oid string) {
-	db.sqlDb.QueryContext(context.Background(), `INSERT INTO wb_scheme.cache (order_uid, app_key) VALUES ($1, $2)`, oid, os.Getenv("APP_KEY"))
-	log.Printf("%v: OrderID успешно добавлен в кеш (DB)\n", db.name)
+// withTimeout ограничивает контекст ctx временем timeout. Нулевое значение не добавляет ограничения.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-// ClearCache очищает кеш базы данных.
-func (db *DB) ClearCache() {
-	_, err := db.sqlDb.ExecContext(context.Background(), `DELETE FROM cache WHERE app_key = $1`, os.Getenv("APP_KEY"))
+// NewDB создает новый экземпляр DB. Соединение с базой данных устанавливается при первом запросе,
+// готовность базы данных к работе сервиса проверяет WaitReady.
+func NewDB() (*DB, error) {
+	db := &DB{timeouts: TimeoutsFromEnv()}
+	sqlDb, err := db.ConnectDB()
 	if err != nil {
-		log.Printf("%v: ошибка очистки кеша: %s\n", db.name, err)
+		return nil, err
 	}
-	log.Printf("%v: кеш успешно очищен из базы данных\n", db.name)
+	db.sqlDb = sqlDb
+	db.cacheW = newCacheWriter(db)
+	return db, nil
 }
 
-// SetCacheInstance устанавливает объект кеша для базы данных.
-func (db *DB) SetCacheInstance(csh *Cache) {
-	db.csh = csh
+// Close дожидается записи состава кеша и закрывает соединение с базой данных.
+// Запись состава кеша и снятие блокировки APP_KEY ограничены контекстом ctx.
+func (db *DB) Close(ctx context.Context) error {
+	writeErr := db.cacheW.close(ctx)
+	db.releaseAppKey(ctx)
+	if err := db.sqlDb.Close(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("не удалось дождаться записи состава кеша: %w", writeErr)
+	}
+	return nil
+}
+
+// SendOrderIDToCache ставит в очередь добавление информации о заказе в кеш базы данных.
+// Если очередь заполнена, изменение отбрасывается после завершения ctx.
+// Запись выполняется в фоне пачками, ошибки записи учитываются в CacheWriterStats.
+func (db *DB) SendOrderIDToCache(ctx context.Context, oid string) {
+	db.cacheW.enqueue(ctx, cacheOp{oid: oid})
+}
+
+// RemoveOrderIDFromCache ставит в очередь удаление информации о вытесненном заказе из кеша базы данных.
+func (db *DB) RemoveOrderIDFromCache(ctx context.Context, oid string) {
+	db.cacheW.enqueue(ctx, cacheOp{oid: oid, remove: true})
+}
+
+// CacheWriterStats возвращает счетчики фоновой записи состава кеша.
+func (db *DB) CacheWriterStats() CacheWriterStats {
+	return db.cacheW.stats()
 }
 
 // GetCacheState получает состояние кеша.
-func (db *DB) GetCacheState(bufSize int) (map[string]interface{}, []string, int, error) {
-	buffer := make(map[string]interface{}, bufSize)
-	queue := make([]string, bufSize)
-	var queueInd int
+// Сохраненные order_uid читаются из wb_scheme.cache, после чего сами заказы загружаются
+// из базы данных одним запросом. Заказы, которых больше нет в базе данных, пропускаются.
+// Возвращаемая очередь идет от старых заказов к новым. Если bufSize не больше нуля,
+// загружаются все сохраненные заказы.
+func (db *DB) GetCacheState(ctx context.Context, bufSize int) ([]string, map[string]Order, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Batch)
+	defer cancel()
+
+	buffer := make(map[string]Order)
+	queue := make([]string, 0)
+
+	// LIMIT NULL в PostgreSQL означает отсутствие ограничения
+	var limit interface{}
+	if bufSize > 0 {
+		limit = bufSize
+	}
 
-	query := fmt.Sprintf("SELECT wb_scheme.cache.order_uid FROM wb_scheme.cache WHERE app_key = '%s' ORDER BY id DESC LIMIT %d", os.Getenv("APP_KEY"), bufSize)
-	rows, err := db.sqlDb.QueryContext(context.Background(), query)
+	query := `SELECT wb_scheme.cache.order_uid FROM wb_scheme.cache WHERE app_key = $1 ORDER BY id DESC LIMIT $2`
+	rows, err := db.sqlDb.QueryContext(ctx, query, os.Getenv("APP_KEY"), limit)
 	if err != nil {
 		log.Printf("%v: не удалось получить order_uid из базы данных: %v\n", db.name, err)
+		return queue, buffer, err
 	}
 	defer rows.Close()
 
+	var uids []string
 	var oid string
 	for rows.Next() {
 		if err := rows.Scan(&oid); err != nil {
 			log.Printf("%v: не удалось получить oid из строки базы данных: %v\n", db.name, err)
-			return buffer, queue, queueInd, errors.New("не удалось получить oid из строки базы данных")
+			return queue, buffer, errors.New("не удалось получить oid из строки базы данных")
 		}
-		queue[queueInd] = oid
-		queueInd++
+		uids = append(uids, oid)
+	}
+	if err := rows.Err(); err != nil {
+		return queue, buffer, err
+	}
 
-		data, exists := db.csh.Get(oid)
-		if exists {
-			buffer[oid] = data
-		}
+	if len(uids) == 0 {
+		return queue, buffer, errors.New("кеш пуст")
 	}
 
-	if queueInd == 0 {
-		return buffer, queue, queueInd, errors.New("кеш пуст")
+	orders, err := db.GetOrdersByUids(ctx, uids)
+	if err != nil {
+		return queue, buffer, err
+	}
+
+	// Записи идут от новых к старым, поэтому обходим их с конца,
+	// чтобы получить старые данные в начале очереди, а новые - в конце.
+	for i := len(uids) - 1; i >= 0; i-- {
+		order, exists := orders[uids[i]]
+		if !exists {
+			continue
+		}
+		if _, restored := buffer[uids[i]]; restored {
+			continue
+		}
+		buffer[uids[i]] = order
+		queue = append(queue, uids[i])
 	}
 
-	// Разворачиваем очередь, чтобы получить новые данные в начале.
-	for i := 0; i < int(queueInd/2); i++ {
-		queue[i], queue[queueInd-i-1] = queue[queueInd-i-1], queue[i]
+	if len(queue) == 0 {
+		return queue, buffer, errors.New("заказы из кеша не найдены в базе данных")
 	}
 
-	return buffer, queue, queueInd, nil
+	return queue, buffer, nil
+}
+
+// IngestStatus описывает результат сохранения заказа.
+type IngestStatus int
+
+const (
+	IngestInserted  IngestStatus = iota // Заказ сохранен впервые
+	IngestDuplicate                     // Заказ с таким order_uid уже сохранен, данные не изменены
+	IngestUpdated                       // Заказ с таким order_uid заменен новой версией
+)
+
+// String возвращает название результата сохранения заказа.
+func (s IngestStatus) String() string {
+	switch s {
+	case IngestInserted:
+		return "inserted"
+	case IngestDuplicate:
+		return "duplicate"
+	case IngestUpdated:
+		return "updated"
+	default:
+		return "unknown"
+	}
 }
 
 // AddOrderInfo добавляет информацию о заказе в базу данных.
-func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
+// Повторная доставка заказа с уже сохраненным order_uid не является ошибкой:
+// такой заказ определяется до любых изменений и возвращается IngestDuplicate.
+func (db *DB) AddOrderInfo(ctx context.Context, orderData Order) (IngestStatus, error) {
+	return db.ingestOrder(ctx, orderData, false)
+}
+
+// UpsertOrderInfo сохраняет новую версию заказа: если заказ с таким order_uid уже есть,
+// его платеж, доставка и товары заменяются данными orderData.
+func (db *DB) UpsertOrderInfo(ctx context.Context, orderData Order) (IngestStatus, error) {
+	return db.ingestOrder(ctx, orderData, true)
+}
+
+// ingestOrder сохраняет заказ в одной транзакции.
+// Одновременные сохранения одного order_uid упорядочиваются транзакционной advisory-блокировкой.
+func (db *DB) ingestOrder(ctx context.Context, orderData Order, upsert bool) (IngestStatus, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Write)
+	defer cancel()
+
 	// Начинаем транзакцию для выполнения нескольких SQL-запросов.
-	tx, err := db.sqlDb.BeginTx(context.Background(), nil)
+	tx, err := db.sqlDb.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Невозможно начать транзакцию", err)
-		return 0, err
+		return IngestInserted, err
 	}
 	defer tx.Rollback()
 
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, orderData.OrderUID)
+	if err != nil {
+		return IngestInserted, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM wb_scheme.orders WHERE order_uid = $1)`, orderData.OrderUID).Scan(&exists)
+	if err != nil {
+		return IngestInserted, err
+	}
+
+	status := IngestInserted
+	if exists {
+		if !upsert {
+			log.Printf("Заказ %s уже сохранен в базе данных\n", orderData.OrderUID)
+			return IngestDuplicate, nil
+		}
+		if err := deleteOrder(ctx, tx, orderData.OrderUID); err != nil {
+			return IngestInserted, err
+		}
+		status = IngestUpdated
+	}
+
+	if err := insertOrder(ctx, tx, orderData); err != nil {
+		return IngestInserted, err
+	}
+
+	// Если все успешно, фиксируем транзакцию.
+	err = tx.Commit()
+	if err != nil {
+		return IngestInserted, err
+	}
+
+	if status == IngestUpdated {
+		log.Println("Заказ успешно обновлен в базе данных")
+	} else {
+		log.Println("Заказ успешно добавлен в базу данных")
+	}
+
+	return status, nil
+}
+
+// insertOrder вставляет платеж, доставку, товары и сам заказ внутри транзакции tx.
+func insertOrder(ctx context.Context, tx *sql.Tx, orderData Order) error {
+	var err error
 	var lastInsertPaymentID int64
 	var lastInsertDeliveryID int64
 	var lastInsertItemID int64
@@ -109,12 +274,12 @@ func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
 	`
 
-	err = tx.QueryRowContext(context.Background(), stmtPayment, orderData.Payment.Transaction, orderData.Payment.RequestId, orderData.Payment.Currency, orderData.Payment.Provider, orderData.Payment.Amount,
+	err = tx.QueryRowContext(ctx, stmtPayment, orderData.Payment.Transaction, orderData.Payment.RequestId, orderData.Payment.Currency, orderData.Payment.Provider, orderData.Payment.Amount,
 		orderData.Payment.PaymentDt, orderData.Payment.Bank, orderData.Payment.DeliveryCost, orderData.Payment.GoodsTotal, orderData.Payment.CustomFee).Scan(&lastInsertPaymentID)
 
 	if err != nil {
 		fmt.Printf("Ошибка вставки данных о платеже: %v\n", err)
-		return 0, err
+		return err
 	}
 
 	stmtDelivery := `
@@ -122,11 +287,11 @@ func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
 		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
 	`
 
-	err = tx.QueryRowContext(context.Background(), stmtDelivery, orderData.Delivery.Name, orderData.Delivery.Phone, orderData.Delivery.Zip, orderData.Delivery.City, orderData.Delivery.Address, orderData.Delivery.Region, orderData.Delivery.Email).Scan(&lastInsertDeliveryID)
+	err = tx.QueryRowContext(ctx, stmtDelivery, orderData.Delivery.Name, orderData.Delivery.Phone, orderData.Delivery.Zip, orderData.Delivery.City, orderData.Delivery.Address, orderData.Delivery.Region, orderData.Delivery.Email).Scan(&lastInsertDeliveryID)
 
 	if err != nil {
 		fmt.Printf("Ошибка вставки данных о доставке: %v\n", err)
-		return 0, err
+		return err
 	}
 
 	stmtItem := `
@@ -136,11 +301,11 @@ func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
 
 	for _, item := range orderData.Items {
 
-		err = tx.QueryRowContext(context.Background(), stmtItem, item.ChrtID, item.TrackNumber, item.Price, item.RID, item.Name, item.Sale, item.Size, item.TotalPrice, item.NmID, item.Brand, item.Status).Scan(&lastInsertItemID)
+		err = tx.QueryRowContext(ctx, stmtItem, item.ChrtID, item.TrackNumber, item.Price, item.RID, item.Name, item.Sale, item.Size, item.TotalPrice, item.NmID, item.Brand, item.Status).Scan(&lastInsertItemID)
 
 		if err != nil {
 			fmt.Printf("Ошибка вставки данных о товаре: %v\n", err)
-			return 0, err
+			return err
 		}
 
 		orderItemsIds = append(orderItemsIds, lastInsertItemID)
@@ -151,11 +316,11 @@ func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING order_uid
 	`
 
-	err = tx.QueryRowContext(context.Background(), stmtOrder, orderData.OrderUID, lastInsertPaymentID, lastInsertDeliveryID, orderData.TrackNumber, orderData.Entry, orderData.Locale, orderData.InternalSignature, orderData.DeliveryService, orderData.Shardkey, orderData.SMID, orderData.DateCreated, orderData.OofShard, orderData.CustomerID).Scan(&lastOrderItemID)
+	err = tx.QueryRowContext(ctx, stmtOrder, orderData.OrderUID, lastInsertPaymentID, lastInsertDeliveryID, orderData.TrackNumber, orderData.Entry, orderData.Locale, orderData.InternalSignature, orderData.DeliveryService, orderData.Shardkey, orderData.SMID, orderData.DateCreated, orderData.OofShard, orderData.CustomerID).Scan(&lastOrderItemID)
 
 	if err != nil {
 		fmt.Printf("Ошибка вставки данных о заказе: %v\n", err)
-		return 0, err
+		return err
 	}
 
 	stmtOrderItems := `
@@ -165,33 +330,130 @@ func (db *DB) AddOrderInfo(orderData Order) (int64, error) {
 
 	for _, itemId := range orderItemsIds {
 
-		_, err := tx.ExecContext(context.Background(), stmtOrderItems, lastOrderItemID, itemId)
+		_, err := tx.ExecContext(ctx, stmtOrderItems, lastOrderItemID, itemId)
 
 		if err != nil {
 			log.Printf("Не удалось вставить данные (order_items)")
-			return 0, err
+			return err
 		}
 	}
 
-	// Если все успешно, фиксируем транзакцию.
-	err = tx.Commit()
+	return nil
+}
+
+// DeleteOrderInfo удаляет заказ вместе с его платежом, доставкой и товарами.
+// Возвращает ErrInvalidUID для идентификатора недопустимого формата, ErrNotFound, если заказа нет,
+// и ErrUnavailable, если не удалось связаться с базой данных.
+func (db *DB) DeleteOrderInfo(ctx context.Context, orderUid string) error {
+	if !ValidUID(orderUid) {
+		return ErrInvalidUID
+	}
+
+	ctx, cancel := withTimeout(ctx, db.timeouts.Write)
+	defer cancel()
+
+	tx, err := db.sqlDb.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return unavailable(err)
 	}
+	defer tx.Rollback()
 
-	log.Println("Заказ успешно добавлен в базу данных")
+	// Та же блокировка, что и при сохранении заказа
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, orderUid); err != nil {
+		return unavailable(err)
+	}
 
-	return 0, nil
+	err = deleteOrder(ctx, tx, orderUid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Printf("%v: не удалось удалить заказ %s: %v\n", db.name, orderUid, err)
+		return unavailable(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return unavailable(err)
+	}
+	log.Printf("%v: заказ %s удален из базы данных\n", db.name, orderUid)
+	return nil
+}
+
+// deleteOrder удаляет заказ вместе с его платежом, доставкой и товарами внутри транзакции tx.
+func deleteOrder(ctx context.Context, tx *sql.Tx, orderUid string) error {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM wb_scheme.order_items WHERE order_uid = $1 RETURNING item_id`, orderUid)
+	if err != nil {
+		return err
+	}
+	var itemIds []int64
+	for rows.Next() {
+		var itemID int64
+		if err := rows.Scan(&itemID); err != nil {
+			rows.Close()
+			return err
+		}
+		itemIds = append(itemIds, itemID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var paymentID, deliveryID int64
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM wb_scheme.orders WHERE order_uid = $1 RETURNING payment_id, delivery_id
+	`, orderUid).Scan(&paymentID, &deliveryID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM wb_scheme.items WHERE item_id = ANY($1)`, pq.Array(itemIds)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM wb_scheme.payment WHERE id = $1`, paymentID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM wb_scheme.delivery WHERE id = $1`, deliveryID); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // GetOrderByUid получает информацию о заказе по его уникальному идентификатору.
-func (db *DB) GetOrderByUid(orderUid string) (Order, error) {
-	var order Order
+// Заказ вместе с доставкой, оплатой и товарами читается одним запросом.
+// Возвращает ErrInvalidUID для идентификатора недопустимого формата, ErrNotFound - если заказа нет,
+// ErrUnavailable - если не удалось связаться с базой данных. Прочие ошибки, например
+// поврежденные данные заказа, возвращаются без изменений.
+func (db *DB) GetOrderByUid(ctx context.Context, orderUid string) (Order, error) {
+	if !ValidUID(orderUid) {
+		return Order{}, ErrInvalidUID
+	}
+
+	ctx, cancel := withTimeout(ctx, db.timeouts.Read)
+	defer cancel()
+
+	stmt := selectOrdersStmt + ` where wb_scheme.orders.order_uid = $1`
+
+	order, err := scanOrder(db.sqlDb.QueryRowContext(ctx, stmt, orderUid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("%v: не удалось получить заказ %s: %v\n", db.name, orderUid, err)
+		return order, unavailable(err)
+	}
 
-	stmt := `
+	return order, nil
+}
+
+// selectOrdersStmt выбирает заказы вместе с доставкой, оплатой и товарами.
+// Товары заказа агрегируются в JSON-массив, поэтому весь заказ читается из одной строки.
+const selectOrdersStmt = `
 	select wb_scheme.orders.order_uid, wb_scheme.orders.track_number, wb_scheme.orders.entry,
 	wb_scheme.orders.locale, wb_scheme.orders.internal_signature, wb_scheme.orders.delivery_service,
 	wb_scheme.orders.shardkey, wb_scheme.orders.sm_id, wb_scheme.orders.oof_shard, wb_scheme.orders.date_created,
+	wb_scheme.orders.customer_id,
 
 	wb_scheme.delivery.name, wb_scheme.delivery.phone, wb_scheme.delivery.zip, wb_scheme.delivery.city,
 	wb_scheme.delivery.address, wb_scheme.delivery.region, wb_scheme.delivery.email,
@@ -199,65 +461,88 @@ func (db *DB) GetOrderByUid(orderUid string) (Order, error) {
 	wb_scheme.payment.transaction, wb_scheme.payment.request_id, wb_scheme.payment.currency,
 	wb_scheme.payment.provider, wb_scheme.payment.amount, wb_scheme.payment.payment_dt,
 	wb_scheme.payment.bank, wb_scheme.payment.delivery_cost, wb_scheme.payment.goods_total,
-	wb_scheme.payment.custom_fee
+	wb_scheme.payment.custom_fee,
+
+	coalesce((
+		select json_agg(json_build_object(
+			'chrt_id', wb_scheme.items.chrt_id, 'track_number', wb_scheme.items.track_number,
+			'price', wb_scheme.items.price, 'rid', wb_scheme.items.rid, 'name', wb_scheme.items.name,
+			'sale', wb_scheme.items.sale, 'size', wb_scheme.items.size, 'total_price', wb_scheme.items.total_price,
+			'nm_id', wb_scheme.items.nm_id, 'brand', wb_scheme.items.brand, 'status', wb_scheme.items.status
+		) order by wb_scheme.items.item_id)
+		from wb_scheme.order_items
+		inner join wb_scheme.items on wb_scheme.items.item_id = wb_scheme.order_items.item_id
+		where wb_scheme.order_items.order_uid = wb_scheme.orders.order_uid
+	), '[]')
 
 	from wb_scheme.orders
 
 	inner join wb_scheme.delivery on wb_scheme.delivery.id = wb_scheme.orders.delivery_id
 
 	inner join wb_scheme.payment on wb_scheme.payment.id = wb_scheme.orders.payment_id
+`
 
-	where wb_scheme.orders.order_uid = $1
-	`
+// scanOrder читает заказ из строки, полученной запросом selectOrdersStmt.
+func scanOrder(row interface{ Scan(dest ...any) error }) (Order, error) {
+	var order Order
+	var items []byte
 
-	err := db.sqlDb.QueryRowContext(context.Background(), stmt, orderUid).Scan(
+	err := row.Scan(
 		&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale, &order.InternalSignature, &order.DeliveryService,
-		&order.Shardkey, &order.SMID, &order.OofShard, &order.DateCreated,
+		&order.Shardkey, &order.SMID, &order.OofShard, &order.DateCreated, &order.CustomerID,
 
 		&order.Delivery.Name, &order.Delivery.Phone, &order.Delivery.Zip, &order.Delivery.City, &order.Delivery.Address,
 		&order.Delivery.Region, &order.Delivery.Email,
 
 		&order.Payment.Transaction, &order.Payment.RequestId, &order.Payment.Currency, &order.Payment.Provider,
 		&order.Payment.Amount, &order.Payment.PaymentDt, &order.Payment.Bank, &order.Payment.DeliveryCost,
-		&order.Payment.GoodsTotal, &order.Payment.CustomFee)
+		&order.Payment.GoodsTotal, &order.Payment.CustomFee,
 
+		&items)
 	if err != nil {
-		fmt.Print("idd", err)
-		return order, errors.New("не удалось получить заказ из базы данных")
+		return order, err
 	}
 
-	stmtItems := `
-	select wb_scheme.order_items.item_id from wb_scheme.order_items where wb_scheme.order_items.order_uid = $1
-	`
-	rowsItems, err := db.sqlDb.Query(stmtItems, orderUid)
-	if err != nil {
-		fmt.Print("error", err)
-		return order, errors.New("не удалось получить список идентификаторов товаров из базы данных")
+	if err := json.Unmarshal(items, &order.Items); err != nil {
+		return order, fmt.Errorf("не удалось разобрать товары заказа %s: %w", order.OrderUID, err)
 	}
 
-	stmtItem := `
-	select wb_scheme.items.chrt_id, wb_scheme.items.track_number, wb_scheme.items.price, wb_scheme.items.rid,
-	wb_scheme.items.name, wb_scheme.items.sale, wb_scheme.items.size, wb_scheme.items.total_price,
-	wb_scheme.items.nm_id, wb_scheme.items.brand, wb_scheme.items.status
-	from wb_scheme.items where wb_scheme.items.item_id = $1
-	`
+	return order, nil
+}
 
-	var itemID int64
-	for rowsItems.Next() {
-		var item Item
-		if err := rowsItems.Scan(&itemID); err != nil {
-			return order, errors.New("не удалось получить идентификатор товара из строки базы данных")
-		}
+// GetOrdersByUids получает заказы по списку идентификаторов за один запрос к базе данных.
+// Отсутствующие в базе данных идентификаторы не попадают в результат.
+// Если не удалось связаться с базой данных, возвращается ErrUnavailable.
+func (db *DB) GetOrdersByUids(ctx context.Context, orderUids []string) (map[string]Order, error) {
+	orders := make(map[string]Order, len(orderUids))
+	if len(orderUids) == 0 {
+		return orders, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, db.timeouts.Read)
+	defer cancel()
 
-		err = db.sqlDb.QueryRowContext(context.Background(), stmtItem, itemID).Scan(&item.ChrtID, &item.TrackNumber, &item.Price, &item.RID,
-			&item.Name, &item.Sale, &item.Size, &item.TotalPrice, &item.NmID, &item.Brand, &item.Status)
+	stmt := selectOrdersStmt + ` where wb_scheme.orders.order_uid = any($1)`
+	rows, err := db.sqlDb.QueryContext(ctx, stmt, pq.Array(orderUids))
+	if err != nil {
+		log.Printf("%v: не удалось получить заказы из базы данных: %v\n", db.name, err)
+		return orders, unavailable(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
 		if err != nil {
-			return order, errors.New("не удалось получить товар из базы данных")
+			log.Printf("%v: не удалось прочитать заказ из строки базы данных: %v\n", db.name, err)
+			return orders, err
 		}
-		order.Items = append(order.Items, item)
+		orders[order.OrderUID] = order
+	}
+	if err := rows.Err(); err != nil {
+		return orders, unavailable(err)
 	}
 
-	return order, nil
+	return orders, nil
 }
 
 // RunExecCommand выполняет SQL-команду на базе данных.
@@ -0,0 +1,69 @@
+package database_test
+
+import (
+	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/database/repotest"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// benchOrder создает образец заказа repotest.Order с items товарами.
+func benchOrder(uid string, items int) database.Order {
+	order := repotest.Order(uid)
+	item := order.Items[0]
+	order.Items = nil
+	order.Payment.GoodsTotal = 0
+	for i := 0; i < items; i++ {
+		item.ChrtID = 9934930 + i
+		item.RID = fmt.Sprintf("rid%d", i)
+		order.Items = append(order.Items, item)
+		order.Payment.GoodsTotal += item.TotalPrice
+	}
+	return order
+}
+
+// BenchmarkGetOrderByUid сравнивает чтение заказа одним запросом с json_agg (GetOrderByUid)
+// и прежнее чтение с отдельным запросом на каждый товар. Требует WB_TEST_DSN.
+func BenchmarkGetOrderByUid(b *testing.B) {
+	db := database.OpenTestDB(b)
+	ctx := context.Background()
+
+	for _, items := range []int{1, 10, 50} {
+		uid := fmt.Sprintf("bench%d-%d", items, time.Now().UnixNano())
+		if _, err := db.AddOrderInfo(ctx, benchOrder(uid, items)); err != nil {
+			b.Fatalf("не удалось сохранить заказ для измерения: %v", err)
+		}
+		b.Cleanup(func() { db.DeleteOrderInfo(context.Background(), uid) })
+
+		// Оба способа должны читать один и тот же заказ
+		single, err := db.GetOrderByUid(ctx, uid)
+		if err != nil {
+			b.Fatal(err)
+		}
+		perItem, err := database.GetOrderByUidPerItem(ctx, db, uid)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !reflect.DeepEqual(single, perItem) {
+			b.Fatalf("способы чтения вернули разные заказы:\n%+v\n%+v", single, perItem)
+		}
+
+		b.Run(fmt.Sprintf("items=%d/N+1", items), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := database.GetOrderByUidPerItem(ctx, db, uid); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("items=%d/json_agg", items), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := db.GetOrderByUid(ctx, uid); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
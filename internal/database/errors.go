@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+// Ошибки пакета database, по которым вызывающий код может определить причину сбоя.
+var (
+	ErrNotFound    = errors.New("заказ не найден")
+	ErrInvalidUID  = errors.New("некорректный идентификатор заказа")
+	ErrUnavailable = errors.New("база данных недоступна")
+)
+
+// uidPattern описывает допустимый order_uid: латинские буквы, цифры, '-' и '_', не длиннее 64 символов.
+var uidPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidUID сообщает, имеет ли order_uid допустимый формат.
+func ValidUID(uid string) bool {
+	return uidPattern.MatchString(uid)
+}
+
+// IsTransient сообщает, что ошибка временная (потеря соединения, взаимоблокировка, нехватка ресурсов)
+// и операцию имеет смысл повторить позже.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Классы ошибок PostgreSQL: 08 - соединение, 40 - откат транзакции,
+	// 53 - нехватка ресурсов, 57 - вмешательство оператора (например, перезапуск сервера)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "40", "53", "57":
+			return true
+		}
+	}
+
+	return false
+}
+
+// unavailable помечает ошибку соединения или драйвера как ErrUnavailable.
+// Остальные ошибки возвращаются без изменений.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) || !IsTransient(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
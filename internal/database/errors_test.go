@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestUnavailable(t *testing.T) {
+	decodeErr := fmt.Errorf("не удалось разобрать товары заказа x: %w", &json.SyntaxError{})
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad conn", driver.ErrBadConn, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"decode", decodeErr, false},
+		{"canceled", context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		got := unavailable(tt.err)
+		if errors.Is(got, ErrUnavailable) != tt.want {
+			t.Errorf("%s: unavailable(%v) = %v, ErrUnavailable ожидается: %v", tt.name, tt.err, got, tt.want)
+		}
+		if !errors.Is(got, tt.err) {
+			t.Errorf("%s: исходная ошибка потеряна: %v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// OpenTestDB подключается к тестовой базе данных из переменной окружения WB_TEST_DSN
+// и применяет к ней миграции. Если WB_TEST_DSN не задана, тест пропускается.
+func OpenTestDB(t testing.TB) *DB {
+	t.Helper()
+
+	dsn := os.Getenv("WB_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WB_TEST_DSN не задана, тест с PostgreSQL пропущен")
+	}
+
+	sqlDb, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("не удалось открыть тестовую базу данных: %v", err)
+	}
+	if err := sqlDb.Ping(); err != nil {
+		t.Fatalf("тестовая база данных недоступна: %v", err)
+	}
+
+	db := &DB{name: "postgres", sqlDb: sqlDb, timeouts: TimeoutsFromEnv()}
+	db.ready.Store(true)
+	db.cacheW = newCacheWriter(db)
+	t.Cleanup(func() { db.Close(context.Background()) })
+
+	if err := db.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("не удалось применить миграции к тестовой базе данных: %v", err)
+	}
+	return db
+}
+
+// GetOrderByUidPerItem читает заказ так, как до перехода на json_agg: заказ с доставкой и оплатой
+// одним запросом, затем список товаров и по запросу на каждый товар.
+// Используется только для сравнения в BenchmarkGetOrderByUid.
+func GetOrderByUidPerItem(ctx context.Context, db *DB, orderUid string) (Order, error) {
+	var order Order
+
+	stmt := `
+	select wb_scheme.orders.order_uid, wb_scheme.orders.track_number, wb_scheme.orders.entry,
+	wb_scheme.orders.locale, wb_scheme.orders.internal_signature, wb_scheme.orders.delivery_service,
+	wb_scheme.orders.shardkey, wb_scheme.orders.sm_id, wb_scheme.orders.oof_shard, wb_scheme.orders.date_created,
+	wb_scheme.orders.customer_id,
+
+	wb_scheme.delivery.name, wb_scheme.delivery.phone, wb_scheme.delivery.zip, wb_scheme.delivery.city,
+	wb_scheme.delivery.address, wb_scheme.delivery.region, wb_scheme.delivery.email,
+
+	wb_scheme.payment.transaction, wb_scheme.payment.request_id, wb_scheme.payment.currency,
+	wb_scheme.payment.provider, wb_scheme.payment.amount, wb_scheme.payment.payment_dt,
+	wb_scheme.payment.bank, wb_scheme.payment.delivery_cost, wb_scheme.payment.goods_total,
+	wb_scheme.payment.custom_fee
+
+	from wb_scheme.orders
+	inner join wb_scheme.delivery on wb_scheme.delivery.id = wb_scheme.orders.delivery_id
+	inner join wb_scheme.payment on wb_scheme.payment.id = wb_scheme.orders.payment_id
+	where wb_scheme.orders.order_uid = $1
+	`
+
+	err := db.sqlDb.QueryRowContext(ctx, stmt, orderUid).Scan(
+		&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale, &order.InternalSignature, &order.DeliveryService,
+		&order.Shardkey, &order.SMID, &order.OofShard, &order.DateCreated, &order.CustomerID,
+
+		&order.Delivery.Name, &order.Delivery.Phone, &order.Delivery.Zip, &order.Delivery.City, &order.Delivery.Address,
+		&order.Delivery.Region, &order.Delivery.Email,
+
+		&order.Payment.Transaction, &order.Payment.RequestId, &order.Payment.Currency, &order.Payment.Provider,
+		&order.Payment.Amount, &order.Payment.PaymentDt, &order.Payment.Bank, &order.Payment.DeliveryCost,
+		&order.Payment.GoodsTotal, &order.Payment.CustomFee)
+	if err != nil {
+		return order, err
+	}
+
+	rows, err := db.sqlDb.QueryContext(ctx,
+		`select item_id from wb_scheme.order_items where order_uid = $1 order by item_id`, orderUid)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
+
+	var itemIDs []int64
+	for rows.Next() {
+		var itemID int64
+		if err := rows.Scan(&itemID); err != nil {
+			return order, err
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+	if err := rows.Err(); err != nil {
+		return order, err
+	}
+
+	for _, itemID := range itemIDs {
+		var item Item
+		err := db.sqlDb.QueryRowContext(ctx, `
+			select chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+			from wb_scheme.items where item_id = $1`, itemID).Scan(
+			&item.ChrtID, &item.TrackNumber, &item.Price, &item.RID, &item.Name, &item.Sale, &item.Size,
+			&item.TotalPrice, &item.NmID, &item.Brand, &item.Status)
+		if err != nil {
+			return order, err
+		}
+		order.Items = append(order.Items, item)
+	}
+
+	return order, nil
+}
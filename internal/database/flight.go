@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// flightCall описывает выполняющуюся загрузку одного ключа.
+type flightCall[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int                // Количество вызовов, ожидающих загрузку
+	cancel  context.CancelFunc // Отменяет загрузку, когда ее перестали ждать все вызовы
+}
+
+// flightGroup объединяет одновременные загрузки одного и того же ключа в один запрос.
+type flightGroup[K comparable, V any] struct {
+	mutex sync.Mutex
+	calls map[K]*flightCall[V]
+}
+
+// Do выполняет fn для ключа key. Если загрузка этого ключа уже выполняется,
+// вызов дожидается её завершения и возвращает тот же результат.
+// Загрузка общая для всех ожидающих, поэтому отмена ctx одного из них её не прерывает:
+// такой вызов просто перестает ждать. Когда ждать перестают все вызовы, контекст fn отменяется,
+// а следующий вызов для этого ключа начинает новую загрузку.
+func (g *flightGroup[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*flightCall[V])
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(loadCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		g.leave(key, call)
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// leave снимает вызов с ожидания загрузки и отменяет её, если ожидающих не осталось.
+func (g *flightGroup[K, V]) leave(key K, call *flightCall[V]) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	call.cancel()
+}
+
+// run выполняет загрузку и сообщает о ее завершении ожидающим.
+func (g *flightGroup[K, V]) run(ctx context.Context, key K, call *flightCall[V], fn func(ctx context.Context) (V, error)) {
+	call.value, call.err = fn(ctx)
+
+	g.mutex.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mutex.Unlock()
+
+	call.cancel()
+	close(call.done)
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Загрузка продолжается, пока ее ждет хотя бы один вызов.
+func TestFlightGroupKeepsLoadForRemainingWaiter(t *testing.T) {
+	var g flightGroup[string, int]
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	load := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := g.Do(first, "a", load)
+		firstDone <- err
+	}()
+	<-started
+
+	secondDone := make(chan int, 1)
+	go func() {
+		value, _ := g.Do(context.Background(), "a", func(context.Context) (int, error) {
+			t.Error("вторая загрузка не должна запускаться")
+			return 0, nil
+		})
+		secondDone <- value
+	}()
+	waitWaiters(t, &g, "a", 2)
+
+	cancelFirst()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Fatalf("первый вызов вернул %v, ожидалась context.Canceled", err)
+	}
+
+	close(release)
+	if value := <-secondDone; value != 42 {
+		t.Fatalf("второй вызов получил %d, ожидалось 42", value)
+	}
+}
+
+// Когда ждать перестают все вызовы, загрузка отменяется, а следующий вызов начинает новую.
+func TestFlightGroupCancelsLoadWithoutWaiters(t *testing.T) {
+	var g flightGroup[string, int]
+	loadCanceled := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Do(ctx, "a", func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			close(loadCanceled)
+			return 0, ctx.Err()
+		})
+		done <- err
+	}()
+	waitWaiters(t, &g, "a", 1)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("вызов вернул %v, ожидалась context.Canceled", err)
+	}
+
+	select {
+	case <-loadCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("загрузка не отменена после ухода последнего ожидающего")
+	}
+
+	value, err := g.Do(context.Background(), "a", func(context.Context) (int, error) { return 7, nil })
+	if err != nil || value != 7 {
+		t.Fatalf("новый вызов вернул %d, %v; ожидалось 7, nil", value, err)
+	}
+}
+
+// waitWaiters дожидается, пока загрузку key будут ждать n вызовов.
+func waitWaiters(t *testing.T, g *flightGroup[string, int], key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		g.mutex.Lock()
+		call, ok := g.calls[key]
+		waiters := 0
+		if ok {
+			waiters = call.waiters
+		}
+		g.mutex.Unlock()
+		if waiters == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("загрузку %s не ждут %d вызовов", key, n)
+}
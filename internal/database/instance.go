@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// AcquireAppKey закрепляет APP_KEY за текущим экземпляром сервиса.
+// Состав кеша в wb_scheme.cache хранится отдельно для каждого APP_KEY, поэтому два экземпляра
+// с одинаковым ключом перезаписывали бы состав кеша друг друга. Ключ закрепляется сессионной
+// рекомендательной блокировкой PostgreSQL, которая снимается при Close или при обрыве соединения.
+func (db *DB) AcquireAppKey(ctx context.Context) error {
+	appKey := os.Getenv("APP_KEY")
+
+	conn, err := db.sqlDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext('wb_scheme.cache:' || $1))`, appKey).Scan(&locked)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !locked {
+		conn.Close()
+		return fmt.Errorf("APP_KEY %q уже используется другим экземпляром сервиса", appKey)
+	}
+
+	db.appLock = conn
+	log.Printf("%v: APP_KEY %s закреплен за экземпляром сервиса\n", db.name, appKey)
+	return nil
+}
+
+// releaseAppKey снимает блокировку APP_KEY. Если ctx завершится раньше, блокировка
+// снимается сервером PostgreSQL при закрытии соединения.
+func (db *DB) releaseAppKey(ctx context.Context) {
+	if db.appLock == nil {
+		return
+	}
+	_, err := db.appLock.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext('wb_scheme.cache:' || $1))`, os.Getenv("APP_KEY"))
+	if err != nil {
+		log.Printf("%v: не удалось снять блокировку APP_KEY: %v\n", db.name, err)
+	}
+	db.appLock.Close()
+	db.appLock = nil
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository хранит заказы в памяти процесса. Безопасен для одновременного использования.
+// Повторяет поведение PostgresRepository и подходит для тестов и запуска без базы данных.
+type MemoryRepository struct {
+	mutex  sync.RWMutex
+	orders map[string]Order
+}
+
+// NewMemoryRepository создает пустое хранилище заказов в памяти.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{orders: make(map[string]Order)}
+}
+
+// Add сохраняет новый заказ, если заказа с таким order_uid еще нет.
+func (r *MemoryRepository) Add(ctx context.Context, order Order) (IngestStatus, error) {
+	return r.store(ctx, order, false)
+}
+
+// Upsert сохраняет заказ, заменяя ранее сохраненную версию.
+func (r *MemoryRepository) Upsert(ctx context.Context, order Order) (IngestStatus, error) {
+	return r.store(ctx, order, true)
+}
+
+// AddBatch сохраняет заказы пачки по одному через Add.
+func (r *MemoryRepository) AddBatch(ctx context.Context, orders []Order) []BatchResult {
+	results := make([]BatchResult, len(orders))
+	for i, order := range orders {
+		status, err := r.Add(ctx, order)
+		results[i] = BatchResult{OrderUID: order.OrderUID, Status: status, Err: err}
+	}
+	return results
+}
+
+// store сохраняет заказ. Существующий заказ заменяется только при upsert.
+func (r *MemoryRepository) store(ctx context.Context, order Order, upsert bool) (IngestStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return IngestInserted, err
+	}
+	// date_created хранится в PostgreSQL как TIMESTAMPTZ, поэтому некорректная дата не сохраняется
+	if _, err := time.Parse(time.RFC3339, order.DateCreated); err != nil {
+		return IngestInserted, fmt.Errorf("некорректная дата создания заказа %s: %w", order.OrderUID, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := IngestInserted
+	if _, exists := r.orders[order.OrderUID]; exists {
+		if !upsert {
+			return IngestDuplicate, nil
+		}
+		status = IngestUpdated
+	}
+	r.orders[order.OrderUID] = copyOrder(order)
+	return status, nil
+}
+
+// Get возвращает копию сохраненного заказа по order_uid.
+func (r *MemoryRepository) Get(ctx context.Context, orderUID string) (Order, error) {
+	if !ValidUID(orderUID) {
+		return Order{}, ErrInvalidUID
+	}
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	order, exists := r.orders[orderUID]
+	if !exists {
+		return Order{}, ErrNotFound
+	}
+	return copyOrder(order), nil
+}
+
+// GetMany возвращает копии сохраненных заказов по списку order_uid.
+func (r *MemoryRepository) GetMany(ctx context.Context, orderUIDs []string) (map[string]Order, error) {
+	orders := make(map[string]Order, len(orderUIDs))
+	if err := ctx.Err(); err != nil {
+		return orders, err
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, uid := range orderUIDs {
+		if order, exists := r.orders[uid]; exists {
+			orders[uid] = copyOrder(order)
+		}
+	}
+	return orders, nil
+}
+
+// Delete удаляет заказ по order_uid.
+func (r *MemoryRepository) Delete(ctx context.Context, orderUID string) error {
+	if !ValidUID(orderUID) {
+		return ErrInvalidUID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.orders[orderUID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.orders, orderUID)
+	return nil
+}
+
+// List ищет заказы по фильтру с той же сортировкой и курсорами, что и DB.ListOrders.
+func (r *MemoryRepository) List(ctx context.Context, filter OrderFilter) (OrderPage, error) {
+	page := OrderPage{Orders: []Order{}}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultListLimit
+	}
+
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = SortByDateCreated
+	}
+	if sortBy != SortByDateCreated && sortBy != SortByOrderUID {
+		return page, fmt.Errorf("неизвестное поле сортировки: %s", sortBy)
+	}
+
+	var after *orderCursor
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, sortBy, filter.Desc)
+		if err != nil {
+			return page, err
+		}
+		after = &cursor
+	}
+	if err := ctx.Err(); err != nil {
+		return page, err
+	}
+
+	r.mutex.RLock()
+	var found []Order
+	for _, order := range r.orders {
+		if matchOrder(order, filter) && (after == nil || afterCursor(order, *after, sortBy, filter.Desc)) {
+			found = append(found, copyOrder(order))
+		}
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(found, func(i, j int) bool {
+		return lessOrder(found[i], found[j], sortBy) != filter.Desc
+	})
+
+	if len(found) > filter.Limit {
+		found = found[:filter.Limit]
+		last := found[len(found)-1]
+		page.NextCursor = encodeCursor(orderCursor{
+			SortBy:   sortBy,
+			Desc:     filter.Desc,
+			Value:    last.DateCreated,
+			OrderUID: last.OrderUID,
+		})
+	}
+	page.Orders = append(page.Orders, found...)
+
+	return page, nil
+}
+
+// matchOrder сообщает, подходит ли заказ под условия фильтра.
+func matchOrder(order Order, filter OrderFilter) bool {
+	created, _ := time.Parse(time.RFC3339, order.DateCreated)
+
+	switch {
+	case filter.TrackNumber != "" && order.TrackNumber != filter.TrackNumber,
+		filter.CustomerID != nil && order.CustomerID != *filter.CustomerID,
+		filter.Phone != "" && order.Delivery.Phone != filter.Phone,
+		filter.Email != "" && order.Delivery.Email != filter.Email,
+		filter.Transaction != "" && order.Payment.Transaction != filter.Transaction,
+		filter.CreatedFrom != nil && created.Before(*filter.CreatedFrom),
+		filter.CreatedTo != nil && !created.Before(*filter.CreatedTo):
+		return false
+	}
+	return true
+}
+
+// lessOrder сравнивает заказы по полю сортировки, при равенстве - по order_uid.
+func lessOrder(a, b Order, sortBy string) bool {
+	if sortBy == SortByDateCreated {
+		ta, _ := time.Parse(time.RFC3339, a.DateCreated)
+		tb, _ := time.Parse(time.RFC3339, b.DateCreated)
+		if !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+	}
+	return a.OrderUID < b.OrderUID
+}
+
+// afterCursor сообщает, идет ли заказ после позиции курсора в выбранной сортировке.
+func afterCursor(order Order, cursor orderCursor, sortBy string, desc bool) bool {
+	position := Order{OrderUID: cursor.OrderUID, DateCreated: cursor.Value}
+	if desc {
+		return lessOrder(order, position, sortBy)
+	}
+	return lessOrder(position, order, sortBy)
+}
+
+// copyOrder копирует заказ вместе со списком товаров, чтобы хранилище не разделяло его с вызывающим.
+func copyOrder(order Order) Order {
+	order.Items = append([]Item(nil), order.Items...)
+	return order
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Миграции хранятся в файлах migrations/NNNN_название.up.sql и migrations/NNNN_название.down.sql,
+// где NNNN - номер версии схемы.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration описывает одну версию схемы базы данных.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations читает встроенные миграции, упорядоченные по версии.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("некорректное имя файла миграции: %s", fileName)
+		}
+
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный номер миграции: %s", fileName)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("для миграции %04d нет файла up или down", m.version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// LatestSchemaVersion возвращает версию схемы, которую ожидает сервис.
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].version, nil
+}
+
+// ensureMigrationsTable создает таблицу примененных миграций.
+// Таблица хранится в схеме public, чтобы пережить откат начальной миграции.
+func (db *DB) ensureMigrationsTable(ctx context.Context) error {
+	_, err := db.sqlDb.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	return err
+}
+
+// SchemaVersion возвращает текущую версию схемы базы данных, 0 - миграции не применялись.
+// Схему не изменяет: если таблицы примененных миграций нет, возвращает 0.
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := db.sqlDb.QueryRowContext(ctx, `SELECT to_regclass('public.schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = db.sqlDb.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM public.schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// ErrSchemaVersion сообщает, что версия схемы базы данных не совпадает с ожидаемой сервисом.
+// Повторные попытки подключения ее не исправят: нужно выполнить команду migrate.
+var ErrSchemaVersion = errors.New("версия схемы базы данных не совпадает с ожидаемой")
+
+// CheckSchemaVersion проверяет, что схема базы данных соответствует версии, которую ожидает сервис.
+// Если версии не совпадают, возвращает ErrSchemaVersion.
+func (db *DB) CheckSchemaVersion(ctx context.Context) error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось получить версию схемы базы данных: %w", err)
+	}
+
+	if current != latest {
+		return fmt.Errorf("%w: версия %d, ожидается %d, выполните команду migrate", ErrSchemaVersion, current, latest)
+	}
+	return nil
+}
+
+// MigrateUp применяет все еще не примененные миграции.
+func (db *DB) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.applyMigration(ctx, m.up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO public.schema_migrations (version) VALUES ($1)`, m.version)
+			return err
+		}); err != nil {
+			return fmt.Errorf("миграция %04d_%s: %w", m.version, m.name, err)
+		}
+		log.Printf("%v: применена миграция %04d_%s\n", db.name, m.version, m.name)
+	}
+
+	return nil
+}
+
+// MigrateDown откатывает последнюю примененную миграцию.
+func (db *DB) MigrateDown(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		log.Printf("%v: нет примененных миграций\n", db.name)
+		return nil
+	}
+
+	for _, m := range migrations {
+		if m.version != current {
+			continue
+		}
+		if err := db.applyMigration(ctx, m.down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM public.schema_migrations WHERE version = $1`, m.version)
+			return err
+		}); err != nil {
+			return fmt.Errorf("откат миграции %04d_%s: %w", m.version, m.name, err)
+		}
+		log.Printf("%v: откачена миграция %04d_%s\n", db.name, m.version, m.name)
+		return nil
+	}
+
+	return fmt.Errorf("миграция версии %d не найдена", current)
+}
+
+// applyMigration выполняет SQL миграции и обновление таблицы версий в одной транзакции.
+func (db *DB) applyMigration(ctx context.Context, stmt string, record func(tx *sql.Tx) error) error {
+	tx, err := db.sqlDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS wb_scheme.cache;
+DROP TABLE IF EXISTS wb_scheme.order_items;
+DROP TABLE IF EXISTS wb_scheme.orders;
+DROP TABLE IF EXISTS wb_scheme.items;
+DROP TABLE IF EXISTS wb_scheme.delivery;
+DROP TABLE IF EXISTS wb_scheme.payment;
+DROP SCHEMA IF EXISTS wb_scheme;
@@ -0,0 +1,74 @@
+-- Начальная схема хранения заказов.
+-- Таблицы и индексы создаются с IF NOT EXISTS, чтобы миграцию можно было применить
+-- к базе данных, созданной вручную до появления миграций.
+CREATE SCHEMA IF NOT EXISTS wb_scheme;
+
+CREATE TABLE IF NOT EXISTS wb_scheme.payment (
+    id            BIGSERIAL PRIMARY KEY,
+    transaction   TEXT    NOT NULL,
+    request_id    TEXT    NOT NULL DEFAULT '',
+    currency      TEXT    NOT NULL,
+    provider      TEXT    NOT NULL,
+    amount        INTEGER NOT NULL,
+    payment_dt    TEXT    NOT NULL,
+    bank          TEXT    NOT NULL,
+    delivery_cost INTEGER NOT NULL,
+    goods_total   INTEGER NOT NULL,
+    custom_fee    INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS wb_scheme.delivery (
+    id      BIGSERIAL PRIMARY KEY,
+    name    TEXT NOT NULL,
+    phone   TEXT NOT NULL,
+    zip     TEXT NOT NULL,
+    city    TEXT NOT NULL,
+    address TEXT NOT NULL,
+    region  TEXT NOT NULL,
+    email   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS wb_scheme.items (
+    item_id      BIGSERIAL PRIMARY KEY,
+    chrt_id      INTEGER NOT NULL,
+    track_number TEXT    NOT NULL,
+    price        INTEGER NOT NULL,
+    rid          TEXT    NOT NULL,
+    name         TEXT    NOT NULL,
+    sale         INTEGER NOT NULL,
+    size         INTEGER NOT NULL,
+    total_price  INTEGER NOT NULL,
+    nm_id        INTEGER NOT NULL,
+    brand        TEXT    NOT NULL,
+    status       INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS wb_scheme.orders (
+    order_uid          TEXT PRIMARY KEY,
+    payment_id         BIGINT      NOT NULL REFERENCES wb_scheme.payment (id),
+    delivery_id        BIGINT      NOT NULL REFERENCES wb_scheme.delivery (id),
+    track_number       TEXT        NOT NULL,
+    entry              TEXT        NOT NULL,
+    locale             TEXT        NOT NULL,
+    internal_signature TEXT        NOT NULL DEFAULT '',
+    customer_id        INTEGER     NOT NULL,
+    delivery_service   TEXT        NOT NULL,
+    shardkey           INTEGER     NOT NULL,
+    sm_id              INTEGER     NOT NULL,
+    date_created       TIMESTAMPTZ NOT NULL,
+    oof_shard          INTEGER     NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS wb_scheme.order_items (
+    order_uid TEXT   NOT NULL REFERENCES wb_scheme.orders (order_uid) ON DELETE CASCADE,
+    item_id   BIGINT NOT NULL REFERENCES wb_scheme.items (item_id) ON DELETE CASCADE,
+    PRIMARY KEY (order_uid, item_id)
+);
+
+CREATE TABLE IF NOT EXISTS wb_scheme.cache (
+    id        BIGSERIAL PRIMARY KEY,
+    order_uid TEXT NOT NULL,
+    app_key   TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS cache_app_key_id_idx ON wb_scheme.cache (app_key, id);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS wb_scheme.payment_transaction_idx;
+DROP INDEX IF EXISTS wb_scheme.delivery_email_idx;
+DROP INDEX IF EXISTS wb_scheme.delivery_phone_idx;
+DROP INDEX IF EXISTS wb_scheme.orders_delivery_id_idx;
+DROP INDEX IF EXISTS wb_scheme.orders_payment_id_idx;
+DROP INDEX IF EXISTS wb_scheme.orders_date_created_order_uid_idx;
+DROP INDEX IF EXISTS wb_scheme.orders_customer_id_idx;
+DROP INDEX IF EXISTS wb_scheme.orders_track_number_idx;
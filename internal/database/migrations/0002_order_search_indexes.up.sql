@@ -0,0 +1,9 @@
+-- Индексы для поиска заказов и постраничной выдачи по курсору.
+CREATE INDEX IF NOT EXISTS orders_track_number_idx ON wb_scheme.orders (track_number);
+CREATE INDEX IF NOT EXISTS orders_customer_id_idx ON wb_scheme.orders (customer_id);
+CREATE INDEX IF NOT EXISTS orders_date_created_order_uid_idx ON wb_scheme.orders (date_created, order_uid);
+CREATE INDEX IF NOT EXISTS orders_payment_id_idx ON wb_scheme.orders (payment_id);
+CREATE INDEX IF NOT EXISTS orders_delivery_id_idx ON wb_scheme.orders (delivery_id);
+CREATE INDEX IF NOT EXISTS delivery_phone_idx ON wb_scheme.delivery (phone);
+CREATE INDEX IF NOT EXISTS delivery_email_idx ON wb_scheme.delivery (email);
+CREATE INDEX IF NOT EXISTS payment_transaction_idx ON wb_scheme.payment (transaction);
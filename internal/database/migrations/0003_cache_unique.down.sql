@@ -0,0 +1 @@
+DROP INDEX IF EXISTS wb_scheme.cache_app_key_order_uid_idx;
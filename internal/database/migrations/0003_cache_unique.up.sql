@@ -0,0 +1,6 @@
+-- Каждый заказ входит в состав кеша экземпляра сервиса не более одного раза.
+DELETE FROM wb_scheme.cache a
+USING wb_scheme.cache b
+WHERE a.app_key = b.app_key AND a.order_uid = b.order_uid AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS cache_app_key_order_uid_idx ON wb_scheme.cache (app_key, order_uid);
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"unsafe"
+)
+
+// OrderCache представляет кэш заказов поверх хранилища заказов.
+type OrderCache struct {
+	*Cache[string, Order]
+	Repo OrderRepository // Хранилище заказов, из которого загружаются промахи кэша
+}
+
+// NewCache создает новый экземпляр кэша заказов и восстанавливает его из снимка или из store.
+// Промахи кэша загружаются из repo. Если store равен nil, состав кэша нигде не сохраняется.
+func NewCache(repo OrderRepository, store CacheStore[string, Order]) *OrderCache {
+	name := "Cache"
+	return &OrderCache{
+		Cache: NewCacheOf[string, Order](name, CacheConfigFromEnv(name), store, repo.Get, orderSize),
+		Repo:  repo,
+	}
+}
+
+// orderCacheStore сохраняет состав кэша заказов в таблице wb_scheme.cache.
+type orderCacheStore struct {
+	db *DB
+}
+
+// NewOrderCacheStore создает хранилище состава кэша заказов в таблице wb_scheme.cache базы данных db.
+func NewOrderCacheStore(db *DB) CacheStore[string, Order] {
+	return orderCacheStore{db: db}
+}
+
+// Restore загружает сохраненные заказы из базы данных.
+func (s orderCacheStore) Restore(ctx context.Context, size int) ([]string, map[string]Order, error) {
+	return s.db.GetCacheState(ctx, size)
+}
+
+// Added записывает order_uid нового элемента кэша в базу данных.
+// Если очередь записи заполнена, место ожидается не дольше CACHE_WRITER_ENQUEUE_WAIT.
+func (s orderCacheStore) Added(oid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.db.timeouts.CacheEnqueue)
+	defer cancel()
+	s.db.SendOrderIDToCache(ctx, oid)
+}
+
+// Evicted удаляет order_uid вытесненного элемента из базы данных.
+func (s orderCacheStore) Evicted(oid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.db.timeouts.CacheEnqueue)
+	defer cancel()
+	s.db.RemoveOrderIDFromCache(ctx, oid)
+}
+
+// orderSize оценивает размер заказа в памяти в байтах:
+// размер структур плюс длина всех строковых полей, включая поля товаров.
+func orderSize(order Order) int64 {
+	size := int64(unsafe.Sizeof(order))
+	size += int64(len(order.OrderUID) + len(order.TrackNumber) + len(order.Entry) + len(order.Locale) +
+		len(order.InternalSignature) + len(order.DeliveryService) + len(order.DateCreated))
+
+	delivery := order.Delivery
+	size += int64(len(delivery.Name) + len(delivery.Phone) + len(delivery.Zip) + len(delivery.City) +
+		len(delivery.Address) + len(delivery.Region) + len(delivery.Email))
+
+	payment := order.Payment
+	size += int64(len(payment.Transaction) + len(payment.RequestId) + len(payment.Currency) +
+		len(payment.Provider) + len(payment.PaymentDt) + len(payment.Bank))
+
+	for _, item := range order.Items {
+		size += int64(unsafe.Sizeof(item))
+		size += int64(len(item.TrackNumber) + len(item.RID) + len(item.Name) + len(item.Brand))
+	}
+
+	return size
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Названия политик вытеснения, которые можно указать в CACHE_POLICY.
+const (
+	PolicyFIFO = "fifo"
+	PolicyLRU  = "lru"
+	PolicyLFU  = "lfu"
+	PolicyTTL  = "ttl"
+)
+
+// evictionPolicy определяет порядок, в котором элементы вытесняются из кэша.
+// Политика хранит только ключи, сами значения хранятся в кэше.
+// Методы вызываются под мьютексом кэша.
+type evictionPolicy[K comparable] interface {
+	// add регистрирует новый ключ или обновление существующего.
+	add(key K)
+	// access отмечает попадание в кэш по ключу.
+	access(key K)
+	// remove удаляет ключ из политики.
+	remove(key K)
+	// victim возвращает ключ, который следует вытеснить первым.
+	victim() (K, bool)
+	// expired сообщает, истек ли срок жизни элемента.
+	expired(key K) bool
+	// keys возвращает ключи от первого кандидата на вытеснение к последнему.
+	keys() []K
+}
+
+// newEvictionPolicy создает политику вытеснения по ее названию.
+func newEvictionPolicy[K comparable](name string, ttl time.Duration) (evictionPolicy[K], error) {
+	switch name {
+	case "", PolicyFIFO:
+		return newOrderedPolicy[K](false), nil
+	case PolicyLRU:
+		return newOrderedPolicy[K](true), nil
+	case PolicyLFU:
+		return newLFUPolicy[K](), nil
+	case PolicyTTL:
+		if ttl <= 0 {
+			return nil, fmt.Errorf("для политики %s требуется положительный CACHE_TTL", PolicyTTL)
+		}
+		return newTTLPolicy[K](ttl), nil
+	default:
+		return nil, fmt.Errorf("неизвестная политика вытеснения: %s", name)
+	}
+}
+
+// orderedPolicy хранит ключи в порядке добавления (FIFO) или последнего использования (LRU).
+type orderedPolicy[K comparable] struct {
+	order    *list.List
+	elements map[K]*list.Element
+	recency  bool // Перемещать ли ключ в конец очереди при использовании (LRU)
+}
+
+func newOrderedPolicy[K comparable](recency bool) *orderedPolicy[K] {
+	return &orderedPolicy[K]{
+		order:    list.New(),
+		elements: make(map[K]*list.Element),
+		recency:  recency,
+	}
+}
+
+func (p *orderedPolicy[K]) add(key K) {
+	if el, exists := p.elements[key]; exists {
+		if p.recency {
+			p.order.MoveToBack(el)
+		}
+		return
+	}
+	p.elements[key] = p.order.PushBack(key)
+}
+
+func (p *orderedPolicy[K]) access(key K) {
+	if el, exists := p.elements[key]; exists && p.recency {
+		p.order.MoveToBack(el)
+	}
+}
+
+func (p *orderedPolicy[K]) remove(key K) {
+	if el, exists := p.elements[key]; exists {
+		p.order.Remove(el)
+		delete(p.elements, key)
+	}
+}
+
+func (p *orderedPolicy[K]) victim() (K, bool) {
+	el := p.order.Front()
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	return el.Value.(K), true
+}
+
+func (p *orderedPolicy[K]) expired(K) bool {
+	return false
+}
+
+func (p *orderedPolicy[K]) keys() []K {
+	keys := make([]K, 0, p.order.Len())
+	for el := p.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(K))
+	}
+	return keys
+}
+
+// ttlPolicy вытесняет элементы в порядке добавления и считает их устаревшими по истечении ttl.
+// Обновление элемента продлевает срок его жизни.
+type ttlPolicy[K comparable] struct {
+	*orderedPolicy[K]
+	ttl   time.Duration
+	added map[K]time.Time
+	now   func() time.Time // Текущее время, подменяется в тестах
+}
+
+func newTTLPolicy[K comparable](ttl time.Duration) *ttlPolicy[K] {
+	return &ttlPolicy[K]{
+		orderedPolicy: newOrderedPolicy[K](false),
+		ttl:           ttl,
+		added:         make(map[K]time.Time),
+		now:           time.Now,
+	}
+}
+
+func (p *ttlPolicy[K]) add(key K) {
+	if el, exists := p.elements[key]; exists {
+		p.order.MoveToBack(el)
+	} else {
+		p.elements[key] = p.order.PushBack(key)
+	}
+	p.added[key] = p.now()
+}
+
+func (p *ttlPolicy[K]) remove(key K) {
+	p.orderedPolicy.remove(key)
+	delete(p.added, key)
+}
+
+func (p *ttlPolicy[K]) expired(key K) bool {
+	added, exists := p.added[key]
+	return exists && p.now().Sub(added) > p.ttl
+}
+
+// lfuEntry описывает ключ в куче политики LFU.
+type lfuEntry[K comparable] struct {
+	key   K
+	freq  uint64 // Количество обращений к ключу
+	seq   uint64 // Порядковый номер последнего обращения для разрешения равенства частот
+	index int    // Позиция в куче
+}
+
+// lfuHeap упорядочивает ключи по возрастанию частоты, а при равной частоте - по давности обращения.
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int { return len(h) }
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x any) {
+	entry := x.(*lfuEntry[K])
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// lfuPolicy вытесняет наименее часто используемые элементы.
+type lfuPolicy[K comparable] struct {
+	heap    lfuHeap[K]
+	entries map[K]*lfuEntry[K]
+	seq     uint64
+}
+
+func newLFUPolicy[K comparable]() *lfuPolicy[K] {
+	return &lfuPolicy[K]{entries: make(map[K]*lfuEntry[K])}
+}
+
+func (p *lfuPolicy[K]) add(key K) {
+	if _, exists := p.entries[key]; exists {
+		p.access(key)
+		return
+	}
+	p.seq++
+	entry := &lfuEntry[K]{key: key, freq: 1, seq: p.seq}
+	p.entries[key] = entry
+	heap.Push(&p.heap, entry)
+}
+
+func (p *lfuPolicy[K]) access(key K) {
+	entry, exists := p.entries[key]
+	if !exists {
+		return
+	}
+	p.seq++
+	entry.freq++
+	entry.seq = p.seq
+	heap.Fix(&p.heap, entry.index)
+}
+
+func (p *lfuPolicy[K]) remove(key K) {
+	entry, exists := p.entries[key]
+	if !exists {
+		return
+	}
+	heap.Remove(&p.heap, entry.index)
+	delete(p.entries, key)
+}
+
+func (p *lfuPolicy[K]) victim() (K, bool) {
+	if len(p.heap) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.heap[0].key, true
+}
+
+func (p *lfuPolicy[K]) expired(K) bool {
+	return false
+}
+
+func (p *lfuPolicy[K]) keys() []K {
+	entries := make(lfuHeap[K], len(p.heap))
+	copy(entries, p.heap)
+	sort.Slice(entries, entries.Less)
+
+	keys := make([]K, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.key)
+	}
+	return keys
+}
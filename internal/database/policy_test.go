@@ -0,0 +1,138 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// cached возвращает ключи из keys, которые есть в кэше, не затрагивая политику вытеснения.
+func cached(csh *Cache[string, string], keys ...string) []string {
+	var found []string
+	for _, key := range keys {
+		if _, ok := csh.Peek(key); ok {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+// expectCached проверяет, что в кэше остались ровно ключи want из all.
+func expectCached(t *testing.T, csh *Cache[string, string], all []string, want ...string) {
+	t.Helper()
+	got := cached(csh, all...)
+	if len(got) != len(want) {
+		t.Fatalf("в кэше %v, ожидается %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("в кэше %v, ожидается %v", got, want)
+		}
+	}
+}
+
+// FIFO вытесняет элементы в порядке добавления, обращения на порядок не влияют.
+func TestFIFOEvictsOldestInserted(t *testing.T) {
+	csh := newTestCache(t, CacheConfig{Size: 3, Policy: PolicyFIFO})
+	all := []string{"A", "B", "C", "D", "E"}
+
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+	csh.Set("C", "c")
+	csh.Get("A")
+	csh.Set("D", "d")
+	expectCached(t, csh, all, "B", "C", "D")
+
+	// Обновление не переносит элемент в конец очереди
+	csh.Set("B", "b2")
+	csh.Set("E", "e")
+	expectCached(t, csh, all, "C", "D", "E")
+}
+
+// LRU вытесняет элемент, к которому дольше всего не обращались.
+func TestLRUEvictsLeastRecentlyRead(t *testing.T) {
+	csh := newTestCache(t, CacheConfig{Size: 3, Policy: PolicyLRU})
+	all := []string{"A", "B", "C", "D", "E"}
+
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+	csh.Set("C", "c")
+	csh.Get("A")
+	csh.Set("D", "d")
+	expectCached(t, csh, all, "A", "C", "D")
+
+	csh.Get("C")
+	csh.Get("A")
+	csh.Set("E", "e")
+	expectCached(t, csh, all, "A", "C", "E")
+}
+
+// LFU вытесняет элемент с наименьшим числом обращений, а при равенстве - самый давний.
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	csh := newTestCache(t, CacheConfig{Size: 3, Policy: PolicyLFU})
+	all := []string{"A", "B", "C", "D", "E"}
+
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+	csh.Set("C", "c")
+	csh.Get("A")
+	csh.Get("A")
+	csh.Get("C")
+	// B обращались реже всех
+	csh.Set("D", "d")
+	expectCached(t, csh, all, "A", "C", "D")
+
+	// К C обращались дважды, к новому D - один раз
+	csh.Set("E", "e")
+	expectCached(t, csh, all, "A", "C", "E")
+}
+
+// При равной частоте LFU вытесняет элемент, к которому обращались раньше остальных.
+func TestLFUBreaksTiesByAge(t *testing.T) {
+	csh := newTestCache(t, CacheConfig{Size: 3, Policy: PolicyLFU})
+	all := []string{"A", "B", "C", "D"}
+
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+	csh.Set("C", "c")
+	csh.Get("B")
+	csh.Get("A")
+	csh.Get("C")
+	// Частоты равны, дольше всех не обращались к B
+	csh.Set("D", "d")
+	expectCached(t, csh, all, "A", "C", "D")
+}
+
+// TTL считает элемент устаревшим по истечении срока жизни, обновление продлевает срок.
+func TestTTLExpiresAfterTTL(t *testing.T) {
+	csh := newTestCache(t, CacheConfig{Size: 10, Policy: PolicyTTL, TTL: time.Minute})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	csh.policy.(*ttlPolicy[string]).now = func() time.Time { return now }
+
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+
+	now = now.Add(40 * time.Second)
+	if _, ok := csh.Get("A"); !ok {
+		t.Fatal("A устарел раньше срока")
+	}
+	csh.Set("B", "b2")
+
+	now = now.Add(30 * time.Second)
+	if _, ok := csh.Get("A"); ok {
+		t.Fatal("A должен устареть через минуту после добавления")
+	}
+	if got, ok := csh.Get("B"); !ok || got != "b2" {
+		t.Fatalf("Get(B) = %q, %v; обновление должно продлить срок жизни", got, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := csh.Get("B"); ok {
+		t.Fatal("B должен устареть через минуту после обновления")
+	}
+
+	stats := csh.Stats()
+	if stats.Size != 0 || stats.Evictions != 2 {
+		t.Fatalf("Size = %d, Evictions = %d; ожидается 0 и 2", stats.Size, stats.Evictions)
+	}
+}
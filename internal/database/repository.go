@@ -0,0 +1,96 @@
+package database
+
+import "context"
+
+// OrderRepository описывает хранилище заказов.
+// Реализации: PostgresRepository (PostgreSQL) и MemoryRepository (память процесса).
+// Поведение реализаций проверяется общим набором проверок из пакета repotest.
+type OrderRepository interface {
+	// Add сохраняет новый заказ. Если заказ с таким order_uid уже сохранен,
+	// он не изменяется и возвращается IngestDuplicate.
+	Add(ctx context.Context, order Order) (IngestStatus, error)
+	// Upsert сохраняет заказ, заменяя ранее сохраненную версию.
+	Upsert(ctx context.Context, order Order) (IngestStatus, error)
+	// AddBatch сохраняет пачку новых заказов так же, как Add, и возвращает результаты в порядке orders.
+	// Ошибка одного заказа не мешает сохранить остальные.
+	AddBatch(ctx context.Context, orders []Order) []BatchResult
+	// Get возвращает заказ по order_uid, ErrInvalidUID или ErrNotFound.
+	Get(ctx context.Context, orderUID string) (Order, error)
+	// GetMany возвращает заказы по списку order_uid. Отсутствующие заказы не попадают в результат.
+	GetMany(ctx context.Context, orderUIDs []string) (map[string]Order, error)
+	// List возвращает страницу заказов, подходящих под фильтр.
+	List(ctx context.Context, filter OrderFilter) (OrderPage, error)
+	// Delete удаляет заказ, возвращает ErrInvalidUID или ErrNotFound.
+	Delete(ctx context.Context, orderUID string) error
+}
+
+// PostgresRepository хранит заказы в PostgreSQL.
+// Пока база данных не готова (см. DB.WaitReady), все операции возвращают ErrUnavailable.
+type PostgresRepository struct {
+	db *DB
+}
+
+// NewPostgresRepository создает хранилище заказов поверх соединения db.
+func NewPostgresRepository(db *DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// Add сохраняет новый заказ через DB.AddOrderInfo.
+func (r *PostgresRepository) Add(ctx context.Context, order Order) (IngestStatus, error) {
+	if !r.db.Ready() {
+		return IngestInserted, ErrUnavailable
+	}
+	return r.db.AddOrderInfo(ctx, order)
+}
+
+// Upsert сохраняет заказ, заменяя ранее сохраненную версию, через DB.UpsertOrderInfo.
+func (r *PostgresRepository) Upsert(ctx context.Context, order Order) (IngestStatus, error) {
+	if !r.db.Ready() {
+		return IngestInserted, ErrUnavailable
+	}
+	return r.db.UpsertOrderInfo(ctx, order)
+}
+
+// AddBatch сохраняет пачку новых заказов одной транзакцией через DB.AddOrdersBatch.
+func (r *PostgresRepository) AddBatch(ctx context.Context, orders []Order) []BatchResult {
+	if !r.db.Ready() {
+		results := make([]BatchResult, len(orders))
+		for i, order := range orders {
+			results[i] = BatchResult{OrderUID: order.OrderUID, Err: ErrUnavailable}
+		}
+		return results
+	}
+	return r.db.AddOrdersBatch(ctx, orders)
+}
+
+// Get загружает заказ с товарами одним запросом через DB.GetOrderByUid.
+func (r *PostgresRepository) Get(ctx context.Context, orderUID string) (Order, error) {
+	if !r.db.Ready() {
+		return Order{}, ErrUnavailable
+	}
+	return r.db.GetOrderByUid(ctx, orderUID)
+}
+
+// GetMany загружает заказы по списку order_uid одним запросом через DB.GetOrdersByUids.
+func (r *PostgresRepository) GetMany(ctx context.Context, orderUIDs []string) (map[string]Order, error) {
+	if !r.db.Ready() {
+		return map[string]Order{}, ErrUnavailable
+	}
+	return r.db.GetOrdersByUids(ctx, orderUIDs)
+}
+
+// List ищет заказы по фильтру через DB.ListOrders.
+func (r *PostgresRepository) List(ctx context.Context, filter OrderFilter) (OrderPage, error) {
+	if !r.db.Ready() {
+		return OrderPage{Orders: []Order{}}, ErrUnavailable
+	}
+	return r.db.ListOrders(ctx, filter)
+}
+
+// Delete удаляет заказ вместе с доставкой, оплатой и товарами через DB.DeleteOrderInfo.
+func (r *PostgresRepository) Delete(ctx context.Context, orderUID string) error {
+	if !r.db.Ready() {
+		return ErrUnavailable
+	}
+	return r.db.DeleteOrderInfo(ctx, orderUID)
+}
@@ -0,0 +1,39 @@
+package database_test
+
+import (
+	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/database/repotest"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	if err := repotest.TestRepository(context.Background(), database.NewMemoryRepository()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresRepository(t *testing.T) {
+	db := database.OpenTestDB(t)
+	if err := repotest.TestRepository(context.Background(), database.NewPostgresRepository(db)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// До готовности базы данных хранилище не обращается к ней и сразу возвращает ErrUnavailable.
+func TestPostgresRepositoryUnavailableUntilReady(t *testing.T) {
+	db, err := database.NewDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+
+	repo := database.NewPostgresRepository(db)
+	if _, err := repo.Get(context.Background(), "not-ready"); !errors.Is(err, database.ErrUnavailable) {
+		t.Fatalf("Get до готовности базы данных: ожидается ErrUnavailable, получено %v", err)
+	}
+	if _, err := repo.List(context.Background(), database.OrderFilter{}); !errors.Is(err, database.ErrUnavailable) {
+		t.Fatalf("List до готовности базы данных: ожидается ErrUnavailable, получено %v", err)
+	}
+}
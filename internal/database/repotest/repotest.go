@@ -0,0 +1,502 @@
+// Пакет repotest содержит общий набор проверок реализаций database.OrderRepository.
+// Набор не зависит от пакета testing, как testing/fstest: TestRepository возвращает ошибку
+// со всеми найденными расхождениями, поэтому его можно вызвать и из теста, и из отдельной программы.
+package repotest
+
+import (
+	"WBTech_L0/internal/database"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TestRepository проверяет, что repo ведет себя как database.OrderRepository:
+// сохранение по одному и пачкой, повторное сохранение, замена, чтение по одному и списком,
+// поиск с постраничной выдачей и удаление заказов.
+// Хранилище не обязано быть пустым: проверка работает со своими заказами с уникальным track_number
+// и удаляет их по завершении.
+func TestRepository(ctx context.Context, repo database.OrderRepository) error {
+	t := &checker{
+		ctx:    ctx,
+		repo:   repo,
+		prefix: fmt.Sprintf("rt%d", time.Now().UnixNano()),
+	}
+	defer t.cleanup()
+
+	t.testMissing()
+	t.testAddGet()
+	t.testGetMany()
+	t.testAddBatch()
+	t.testUpsert()
+	t.testList()
+	t.testDelete()
+	t.testConcurrentAdd()
+
+	return errors.Join(t.errs...)
+}
+
+// checker накапливает расхождения одного запуска TestRepository.
+type checker struct {
+	ctx     context.Context
+	repo    database.OrderRepository
+	prefix  string   // Префикс order_uid и track_number заказов запуска
+	created []string // order_uid сохраненных заказов для удаления по завершении
+	errs    []error
+}
+
+func (t *checker) errorf(format string, args ...any) {
+	t.errs = append(t.errs, fmt.Errorf(format, args...))
+}
+
+// Order возвращает образец заказа WB с order_uid и payment.transaction, равными uid.
+// Заказ проходит проверку validation.Validate и содержит один товар; тесты других пакетов
+// используют его как общий образец, изменяя нужные поля в копии.
+func Order(uid string) database.Order {
+	return database.Order{
+		OrderUID:    uid,
+		TrackNumber: "WBILMTESTTRACK",
+		Entry:       "WBIL",
+		Delivery: database.Delivery{
+			Name:    "Test Testov",
+			Phone:   "+9720000000",
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   "test@gmail.com",
+		},
+		Payment: database.Payment{
+			Transaction:  uid,
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       1817,
+			PaymentDt:    "1637907727",
+			Bank:         "alpha",
+			DeliveryCost: 1500,
+			GoodsTotal:   317,
+		},
+		Items: []database.Item{{
+			ChrtID:      9934930,
+			TrackNumber: "WBILMTESTTRACK",
+			Price:       453,
+			RID:         "ab4219087a764ae0btest",
+			Name:        "Mascaras",
+			Sale:        30,
+			Size:        0,
+			TotalPrice:  317,
+			NmID:        2389212,
+			Brand:       "Vivienne Sabo",
+			Status:      202,
+		}},
+		Locale:          "en",
+		CustomerID:      1,
+		DeliveryService: "meest",
+		Shardkey:        9,
+		SMID:            99,
+		DateCreated:     "2021-11-26T06:22:19Z",
+		OofShard:        1,
+	}
+}
+
+// order создает корректный заказ с номером n. Заказы с большим n созданы позже.
+func (t *checker) order(n int) database.Order {
+	uid := fmt.Sprintf("%s-%02d", t.prefix, n)
+	track := "WB" + t.prefix
+
+	order := Order(uid)
+	order.TrackNumber = track
+	order.Delivery.Email = fmt.Sprintf("%s@example.com", uid)
+	order.Items[0].TrackNumber = track
+	order.CustomerID = n
+	order.DateCreated = time.Date(2021, 11, 26, 6, 22, n, 0, time.UTC).Format(time.RFC3339)
+	return order
+}
+
+// batchOrder создает заказ с номером n и track_number, отличным от заказов order.
+func (t *checker) batchOrder(n int) database.Order {
+	order := t.order(n)
+	order.TrackNumber += "B"
+	order.Items[0].TrackNumber = order.TrackNumber
+	return order
+}
+
+// add сохраняет заказ и запоминает его для удаления.
+func (t *checker) add(order database.Order) (database.IngestStatus, error) {
+	status, err := t.repo.Add(t.ctx, order)
+	if err == nil {
+		t.created = append(t.created, order.OrderUID)
+	}
+	return status, err
+}
+
+// cleanup удаляет сохраненные заказы.
+func (t *checker) cleanup() {
+	for _, uid := range t.created {
+		t.repo.Delete(context.WithoutCancel(t.ctx), uid)
+	}
+}
+
+// equal сравнивает заказы. Дата создания сравнивается как момент времени,
+// потому что хранилище может вернуть ее в другом часовом поясе.
+func equal(a, b database.Order) bool {
+	ta, errA := time.Parse(time.RFC3339, a.DateCreated)
+	tb, errB := time.Parse(time.RFC3339, b.DateCreated)
+	if errA != nil || errB != nil || !ta.Equal(tb) {
+		return false
+	}
+	a.DateCreated, b.DateCreated = "", ""
+	if len(a.Items) == 0 && len(b.Items) == 0 {
+		a.Items, b.Items = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func dump(order database.Order) string {
+	data, _ := json.Marshal(order)
+	return string(data)
+}
+
+// uids возвращает order_uid заказов.
+func uids(orders []database.Order) []string {
+	result := make([]string, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, order.OrderUID)
+	}
+	return result
+}
+
+// keys возвращает order_uid найденных заказов.
+func keys(orders map[string]database.Order) []string {
+	result := make([]string, 0, len(orders))
+	for uid := range orders {
+		result = append(result, uid)
+	}
+	return result
+}
+
+func (t *checker) testMissing() {
+	if _, err := t.repo.Get(t.ctx, t.prefix+"-missing"); !errors.Is(err, database.ErrNotFound) {
+		t.errorf("Get несуществующего заказа: ожидается ErrNotFound, получено %v", err)
+	}
+	if _, err := t.repo.Get(t.ctx, "bad uid!"); !errors.Is(err, database.ErrInvalidUID) {
+		t.errorf("Get с некорректным order_uid: ожидается ErrInvalidUID, получено %v", err)
+	}
+	if err := t.repo.Delete(t.ctx, t.prefix+"-missing"); !errors.Is(err, database.ErrNotFound) {
+		t.errorf("Delete несуществующего заказа: ожидается ErrNotFound, получено %v", err)
+	}
+	if err := t.repo.Delete(t.ctx, "bad uid!"); !errors.Is(err, database.ErrInvalidUID) {
+		t.errorf("Delete с некорректным order_uid: ожидается ErrInvalidUID, получено %v", err)
+	}
+}
+
+func (t *checker) testAddGet() {
+	order := t.order(1)
+	status, err := t.add(order)
+	if err != nil || status != database.IngestInserted {
+		t.errorf("Add нового заказа: ожидается %v, получено %v, %v", database.IngestInserted, status, err)
+		return
+	}
+
+	got, err := t.repo.Get(t.ctx, order.OrderUID)
+	if err != nil {
+		t.errorf("Get сохраненного заказа: %v", err)
+		return
+	}
+	if !equal(got, order) {
+		t.errorf("Get вернул другой заказ:\n получено %s\nожидается %s", dump(got), dump(order))
+	}
+
+	changed := order
+	changed.Entry = "CHANGED"
+	status, err = t.repo.Add(t.ctx, changed)
+	if err != nil || status != database.IngestDuplicate {
+		t.errorf("повторный Add: ожидается %v, получено %v, %v", database.IngestDuplicate, status, err)
+	}
+	if got, err := t.repo.Get(t.ctx, order.OrderUID); err != nil || !equal(got, order) {
+		t.errorf("повторный Add не должен изменять сохраненный заказ: %s, %v", dump(got), err)
+	}
+
+	// Изменение полученного заказа не должно влиять на хранилище
+	got.Items[0].Name = "CHANGED"
+	if again, err := t.repo.Get(t.ctx, order.OrderUID); err != nil || !equal(again, order) {
+		t.errorf("изменение полученного заказа изменило хранилище: %s, %v", dump(again), err)
+	}
+}
+
+func (t *checker) testGetMany() {
+	first, second := t.order(5), t.order(6)
+	for _, order := range []database.Order{first, second} {
+		if _, err := t.add(order); err != nil {
+			t.errorf("Add заказа для GetMany: %v", err)
+			return
+		}
+	}
+
+	missing := t.prefix + "-missing"
+	got, err := t.repo.GetMany(t.ctx, []string{second.OrderUID, missing, first.OrderUID})
+	if err != nil {
+		t.errorf("GetMany: %v", err)
+		return
+	}
+	if len(got) != 2 || !equal(got[first.OrderUID], first) || !equal(got[second.OrderUID], second) {
+		t.errorf("GetMany: получено %v, ожидаются %s и %s", keys(got), first.OrderUID, second.OrderUID)
+	}
+
+	if got, err := t.repo.GetMany(t.ctx, nil); err != nil || len(got) != 0 {
+		t.errorf("GetMany без идентификаторов: ожидается пустой результат, получено %v, %v", keys(got), err)
+	}
+}
+
+func (t *checker) testAddBatch() {
+	// Заказы пачки получают отдельный track_number, чтобы не попасть в выдачу testList
+	first, second, broken := t.batchOrder(20), t.batchOrder(21), t.batchOrder(22)
+	broken.DateCreated = "not-a-date"
+
+	results := t.repo.AddBatch(t.ctx, []database.Order{first, second, first, broken})
+	if len(results) != 4 {
+		t.errorf("AddBatch: получено %d результатов, ожидается 4", len(results))
+		return
+	}
+	for _, result := range results {
+		if result.Err == nil && result.Status == database.IngestInserted {
+			t.created = append(t.created, result.OrderUID)
+		}
+	}
+
+	want := []struct {
+		uid    string
+		status database.IngestStatus
+	}{
+		{first.OrderUID, database.IngestInserted},
+		{second.OrderUID, database.IngestInserted},
+		{first.OrderUID, database.IngestDuplicate},
+	}
+	for i, w := range want {
+		if r := results[i]; r.OrderUID != w.uid || r.Err != nil || r.Status != w.status {
+			t.errorf("AddBatch, заказ %d: ожидается %s %v, получено %s %v, %v", i, w.uid, w.status, r.OrderUID, r.Status, r.Err)
+		}
+	}
+	if results[3].OrderUID != broken.OrderUID || results[3].Err == nil {
+		t.errorf("AddBatch заказа с некорректной датой: ожидается ошибка, получено %v", results[3].Err)
+	}
+
+	for _, order := range []database.Order{first, second} {
+		if got, err := t.repo.Get(t.ctx, order.OrderUID); err != nil || !equal(got, order) {
+			t.errorf("Get заказа из пачки:\n получено %s, %v\nожидается %s", dump(got), err, dump(order))
+		}
+	}
+	if _, err := t.repo.Get(t.ctx, broken.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.errorf("заказ с некорректной датой не должен сохраняться: %v", err)
+	}
+
+	results = t.repo.AddBatch(t.ctx, []database.Order{second})
+	if len(results) != 1 || results[0].Err != nil || results[0].Status != database.IngestDuplicate {
+		t.errorf("повторный AddBatch: ожидается %v, получено %+v", database.IngestDuplicate, results)
+	}
+}
+
+func (t *checker) testUpsert() {
+	order := t.order(2)
+	status, err := t.repo.Upsert(t.ctx, order)
+	if err != nil || status != database.IngestInserted {
+		t.errorf("Upsert нового заказа: ожидается %v, получено %v, %v", database.IngestInserted, status, err)
+		return
+	}
+	t.created = append(t.created, order.OrderUID)
+
+	order.Entry = "CHANGED"
+	order.Items = append(order.Items, order.Items[0])
+	order.Payment.GoodsTotal *= 2
+	status, err = t.repo.Upsert(t.ctx, order)
+	if err != nil || status != database.IngestUpdated {
+		t.errorf("Upsert сохраненного заказа: ожидается %v, получено %v, %v", database.IngestUpdated, status, err)
+		return
+	}
+
+	got, err := t.repo.Get(t.ctx, order.OrderUID)
+	if err != nil || !equal(got, order) {
+		t.errorf("Get после Upsert:\n получено %s, %v\nожидается %s", dump(got), err, dump(order))
+	}
+}
+
+func (t *checker) testList() {
+	// Заказы 10-14 создаются в обратном порядке, чтобы порядок выдачи не совпадал с порядком сохранения
+	var want []string
+	for n := 14; n >= 10; n-- {
+		if _, err := t.add(t.order(n)); err != nil {
+			t.errorf("Add заказа для поиска: %v", err)
+			return
+		}
+	}
+	for n := 10; n <= 14; n++ {
+		want = append(want, t.order(n).OrderUID)
+	}
+	reversed := make([]string, len(want))
+	for i, uid := range want {
+		reversed[len(want)-1-i] = uid
+	}
+
+	// Заказы 1 и 2 тоже имеют track_number запуска, поэтому поиск ограничен датой создания
+	from := time.Date(2021, 11, 26, 6, 22, 10, 0, time.UTC)
+	filter := database.OrderFilter{TrackNumber: "WB" + t.prefix, CreatedFrom: &from, Limit: 2}
+
+	for _, c := range []struct {
+		sortBy string
+		desc   bool
+		want   []string
+	}{
+		{database.SortByDateCreated, false, want},
+		{database.SortByDateCreated, true, reversed},
+		{database.SortByOrderUID, false, want},
+		{database.SortByOrderUID, true, reversed},
+	} {
+		f := filter
+		f.SortBy, f.Desc = c.sortBy, c.desc
+		got, err := t.listAll(f)
+		if err != nil {
+			t.errorf("List (sort=%s, desc=%v): %v", c.sortBy, c.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.errorf("List (sort=%s, desc=%v): получено %v, ожидается %v", c.sortBy, c.desc, got, c.want)
+		}
+	}
+
+	to := time.Date(2021, 11, 26, 6, 22, 12, 0, time.UTC)
+	f := filter
+	f.CreatedTo, f.Limit = &to, 10
+	if page, err := t.repo.List(t.ctx, f); err != nil || !reflect.DeepEqual(uids(page.Orders), want[:2]) {
+		t.errorf("List с created_from и created_to: получено %v, %v, ожидается %v", uids(page.Orders), err, want[:2])
+	}
+
+	customer := 13
+	f = filter
+	f.CustomerID, f.Limit = &customer, 10
+	if page, err := t.repo.List(t.ctx, f); err != nil || !reflect.DeepEqual(uids(page.Orders), want[3:4]) {
+		t.errorf("List с customer_id: получено %v, %v, ожидается %v", uids(page.Orders), err, want[3:4])
+	}
+
+	order := t.order(12)
+	f = database.OrderFilter{Email: order.Delivery.Email, Phone: order.Delivery.Phone, Transaction: order.Payment.Transaction}
+	if page, err := t.repo.List(t.ctx, f); err != nil || len(page.Orders) != 1 || !equal(page.Orders[0], order) {
+		t.errorf("List с email, phone и transaction: получено %v, %v, ожидается %v", uids(page.Orders), err, order.OrderUID)
+	}
+
+	f = filter
+	f.Cursor = "not-a-cursor"
+	if _, err := t.repo.List(t.ctx, f); !errors.Is(err, database.ErrInvalidCursor) {
+		t.errorf("List с некорректным курсором: ожидается ErrInvalidCursor, получено %v", err)
+	}
+
+	// Курсор с поврежденной датой отклоняется, а не передается в базу данных
+	for _, value := range []string{"not-a-date", "2021-13-45T99:00:00Z", ""} {
+		forged, _ := json.Marshal(map[string]any{"s": database.SortByDateCreated, "d": false, "v": value, "u": t.order(10).OrderUID})
+		f = filter
+		f.Cursor = base64.RawURLEncoding.EncodeToString(forged)
+		if _, err := t.repo.List(t.ctx, f); !errors.Is(err, database.ErrInvalidCursor) {
+			t.errorf("List с курсором с датой %q: ожидается ErrInvalidCursor, получено %v", value, err)
+		}
+	}
+
+	// Курсор одной сортировки нельзя использовать с другой
+	if page, err := t.repo.List(t.ctx, filter); err == nil && page.NextCursor != "" {
+		f = filter
+		f.Cursor, f.Desc = page.NextCursor, true
+		if _, err := t.repo.List(t.ctx, f); !errors.Is(err, database.ErrInvalidCursor) {
+			t.errorf("List с курсором другой сортировки: ожидается ErrInvalidCursor, получено %v", err)
+		}
+	}
+
+	f = filter
+	f.SortBy = "unknown"
+	if _, err := t.repo.List(t.ctx, f); err == nil {
+		t.errorf("List с неизвестным полем сортировки: ожидается ошибка")
+	}
+
+	f = database.OrderFilter{TrackNumber: t.prefix + "-missing"}
+	if page, err := t.repo.List(t.ctx, f); err != nil || page.Orders == nil || len(page.Orders) != 0 || page.NextCursor != "" {
+		t.errorf("List без результатов: ожидается пустой список без курсора, получено %v, %q, %v", page.Orders, page.NextCursor, err)
+	}
+}
+
+// listAll обходит все страницы выдачи и возвращает order_uid найденных заказов.
+func (t *checker) listAll(filter database.OrderFilter) ([]string, error) {
+	var result []string
+	for pages := 0; pages < 100; pages++ {
+		page, err := t.repo.List(t.ctx, filter)
+		if err != nil {
+			return result, err
+		}
+		if len(page.Orders) > filter.Limit {
+			return result, fmt.Errorf("страница из %d заказов больше лимита %d", len(page.Orders), filter.Limit)
+		}
+		result = append(result, uids(page.Orders)...)
+		if page.NextCursor == "" {
+			return result, nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+	return result, errors.New("слишком много страниц")
+}
+
+func (t *checker) testDelete() {
+	order := t.order(3)
+	if _, err := t.add(order); err != nil {
+		t.errorf("Add заказа для удаления: %v", err)
+		return
+	}
+
+	if err := t.repo.Delete(t.ctx, order.OrderUID); err != nil {
+		t.errorf("Delete сохраненного заказа: %v", err)
+	}
+	if _, err := t.repo.Get(t.ctx, order.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.errorf("Get удаленного заказа: ожидается ErrNotFound, получено %v", err)
+	}
+	if err := t.repo.Delete(t.ctx, order.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.errorf("повторный Delete: ожидается ErrNotFound, получено %v", err)
+	}
+
+	// Удаленный заказ можно сохранить заново
+	if status, err := t.repo.Add(t.ctx, order); err != nil || status != database.IngestInserted {
+		t.errorf("Add удаленного заказа: ожидается %v, получено %v, %v", database.IngestInserted, status, err)
+	}
+}
+
+func (t *checker) testConcurrentAdd() {
+	const writers = 8
+	order := t.order(4)
+
+	var wg sync.WaitGroup
+	statuses := make([]database.IngestStatus, writers)
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i], errs[i] = t.repo.Add(t.ctx, order)
+		}(i)
+	}
+	wg.Wait()
+	t.created = append(t.created, order.OrderUID)
+
+	inserted := 0
+	for i := range statuses {
+		if errs[i] != nil {
+			t.errorf("одновременный Add: %v", errs[i])
+			continue
+		}
+		if statuses[i] == database.IngestInserted {
+			inserted++
+		} else if statuses[i] != database.IngestDuplicate {
+			t.errorf("одновременный Add: неожиданный статус %v", statuses[i])
+		}
+	}
+	if inserted != 1 {
+		t.errorf("одновременный Add одного заказа: сохранен %d раз, ожидается 1", inserted)
+	}
+}
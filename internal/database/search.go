@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Поля сортировки списка заказов.
+const (
+	SortByDateCreated = "date_created"
+	SortByOrderUID    = "order_uid"
+)
+
+// DefaultListLimit - размер страницы по умолчанию.
+const DefaultListLimit = 50
+
+// ErrInvalidCursor сообщает, что курсор постраничной выдачи поврежден или относится к другой сортировке.
+var ErrInvalidCursor = errors.New("некорректный курсор")
+
+// OrderFilter описывает условия поиска заказов. Пустые поля не участвуют в поиске.
+type OrderFilter struct {
+	TrackNumber string
+	CustomerID  *int
+	Phone       string     // delivery.phone
+	Email       string     // delivery.email
+	Transaction string     // payment.transaction
+	CreatedFrom *time.Time // date_created >= CreatedFrom
+	CreatedTo   *time.Time // date_created < CreatedTo
+
+	SortBy string // SortByDateCreated (по умолчанию) или SortByOrderUID
+	Desc   bool   // Сортировка по убыванию
+	Limit  int    // Размер страницы
+	Cursor string // Курсор из предыдущей страницы
+}
+
+// OrderPage содержит страницу найденных заказов.
+// NextCursor пуст, если следующей страницы нет.
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// orderCursor хранит позицию последнего заказа страницы.
+type orderCursor struct {
+	SortBy   string `json:"s"`
+	Desc     bool   `json:"d"`
+	Value    string `json:"v"`
+	OrderUID string `json:"u"`
+}
+
+// encodeCursor кодирует позицию заказа в курсор.
+func encodeCursor(c orderCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor декодирует курсор и проверяет, что он относится к той же сортировке
+// и содержит дату создания заказа в формате RFC 3339.
+func decodeCursor(cursor string, sortBy string, desc bool) (orderCursor, error) {
+	var c orderCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.OrderUID == "" {
+		return c, ErrInvalidCursor
+	}
+	if c.SortBy != sortBy || c.Desc != desc {
+		return c, ErrInvalidCursor
+	}
+	// Значение курсора подставляется в запрос как timestamptz, поэтому поврежденная дата
+	// должна отклоняться здесь, а не ошибкой PostgreSQL
+	if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ListOrders ищет заказы по фильтру и возвращает одну страницу результатов.
+// Используется постраничная выдача по ключу (keyset): следующая страница начинается
+// сразу после последнего заказа предыдущей, поэтому выдача не смещается при добавлении заказов.
+func (db *DB) ListOrders(ctx context.Context, filter OrderFilter) (OrderPage, error) {
+	page := OrderPage{Orders: []Order{}}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultListLimit
+	}
+
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = SortByDateCreated
+	}
+	if sortBy != SortByDateCreated && sortBy != SortByOrderUID {
+		return page, fmt.Errorf("неизвестное поле сортировки: %s", sortBy)
+	}
+
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.TrackNumber != "" {
+		conditions = append(conditions, "wb_scheme.orders.track_number = "+arg(filter.TrackNumber))
+	}
+	if filter.CustomerID != nil {
+		conditions = append(conditions, "wb_scheme.orders.customer_id = "+arg(*filter.CustomerID))
+	}
+	if filter.Phone != "" {
+		conditions = append(conditions, "wb_scheme.delivery.phone = "+arg(filter.Phone))
+	}
+	if filter.Email != "" {
+		conditions = append(conditions, "wb_scheme.delivery.email = "+arg(filter.Email))
+	}
+	if filter.Transaction != "" {
+		conditions = append(conditions, "wb_scheme.payment.transaction = "+arg(filter.Transaction))
+	}
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "wb_scheme.orders.date_created >= "+arg(*filter.CreatedFrom))
+	}
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "wb_scheme.orders.date_created < "+arg(*filter.CreatedTo))
+	}
+
+	op, direction := ">", "asc"
+	if filter.Desc {
+		op, direction = "<", "desc"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, sortBy, filter.Desc)
+		if err != nil {
+			return page, err
+		}
+		if sortBy == SortByDateCreated {
+			conditions = append(conditions, fmt.Sprintf("(wb_scheme.orders.date_created, wb_scheme.orders.order_uid) %s (%s::timestamptz, %s)",
+				op, arg(cursor.Value), arg(cursor.OrderUID)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("wb_scheme.orders.order_uid %s %s", op, arg(cursor.OrderUID)))
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx, db.timeouts.Read)
+	defer cancel()
+
+	stmt := selectOrdersStmt
+	if len(conditions) > 0 {
+		stmt += " where " + strings.Join(conditions, " and ")
+	}
+	if sortBy == SortByDateCreated {
+		stmt += fmt.Sprintf(" order by wb_scheme.orders.date_created %s, wb_scheme.orders.order_uid %s", direction, direction)
+	} else {
+		stmt += fmt.Sprintf(" order by wb_scheme.orders.order_uid %s", direction)
+	}
+	// Запрашиваем на один заказ больше, чтобы узнать, есть ли следующая страница
+	stmt += " limit " + arg(filter.Limit+1)
+
+	rows, err := db.sqlDb.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("%v: не удалось выполнить поиск заказов: %v\n", db.name, err)
+		return page, unavailable(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Orders = append(page.Orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return page, unavailable(err)
+	}
+
+	if len(page.Orders) > filter.Limit {
+		page.Orders = page.Orders[:filter.Limit]
+		last := page.Orders[len(page.Orders)-1]
+		page.NextCursor = encodeCursor(orderCursor{
+			SortBy:   sortBy,
+			Desc:     filter.Desc,
+			Value:    last.DateCreated,
+			OrderUID: last.OrderUID,
+		})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Формат файла снимка кэша:
+//
+//	magic   [4]byte - сигнатура "WBCS"
+//	version uint16  - версия формата
+//	created int64   - время создания снимка в наносекундах Unix
+//	length  uint32  - длина данных
+//	crc     uint32  - контрольная сумма CRC-32 (IEEE) данных
+//	payload []byte  - данные снимка в формате gob
+//
+// Все числа записываются в порядке big-endian.
+const (
+	snapshotMagic   = "WBCS"
+	snapshotVersion = 1
+)
+
+// errSnapshotStale сообщает, что снимок старше допустимого возраста.
+var errSnapshotStale = errors.New("снимок кэша устарел")
+
+// snapshotHeader описывает заголовок файла снимка после сигнатуры.
+type snapshotHeader struct {
+	Version uint16
+	Created int64
+	Length  uint32
+	CRC     uint32
+}
+
+// snapshotPayload содержит элементы кэша от первого кандидата на вытеснение к последнему.
+type snapshotPayload[K comparable, V any] struct {
+	Keys   []K
+	Values []V
+}
+
+// writeSnapshot атомарно записывает элементы кэша в файл path.
+func writeSnapshot[K comparable, V any](path string, keys []K, values map[K]V) error {
+	payload := snapshotPayload[K, V]{Keys: keys, Values: make([]V, 0, len(keys))}
+	for _, key := range keys {
+		payload.Values = append(payload.Values, values[key])
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(payload); err != nil {
+		return fmt.Errorf("не удалось закодировать снимок: %w", err)
+	}
+
+	header := snapshotHeader{
+		Version: snapshotVersion,
+		Created: time.Now().UnixNano(),
+		Length:  uint32(data.Len()),
+		CRC:     crc32.ChecksumIEEE(data.Bytes()),
+	}
+
+	// Пишем во временный файл и переименовываем его, чтобы не оставить поврежденный снимок
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(snapshotMagic)
+	binary.Write(w, binary.BigEndian, header)
+	w.Write(data.Bytes())
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readSnapshot читает элементы кэша из файла path.
+// Снимок старше maxAge не загружается, если maxAge больше нуля.
+func readSnapshot[K comparable, V any](path string, maxAge time.Duration) ([]K, map[K]V, time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, nil, time.Time{}, errors.New("файл не является снимком кэша")
+	}
+
+	var header snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("не удалось прочитать заголовок снимка: %w", err)
+	}
+	if header.Version != snapshotVersion {
+		return nil, nil, time.Time{}, fmt.Errorf("неподдерживаемая версия снимка: %d", header.Version)
+	}
+
+	created := time.Unix(0, header.Created)
+	if maxAge > 0 && time.Since(created) > maxAge {
+		return nil, nil, created, errSnapshotStale
+	}
+
+	// Длина данных берется из файла, поэтому до выделения памяти сверяем ее с размером файла
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, created, err
+	}
+	if remaining := info.Size() - int64(len(snapshotMagic)+binary.Size(header)); int64(header.Length) > remaining {
+		return nil, nil, created, fmt.Errorf("снимок обрезан: длина данных %d, в файле осталось %d байт", header.Length, remaining)
+	}
+
+	data := make([]byte, header.Length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, created, fmt.Errorf("снимок обрезан: %w", err)
+	}
+	if crc32.ChecksumIEEE(data) != header.CRC {
+		return nil, nil, created, errors.New("контрольная сумма снимка не совпадает")
+	}
+
+	var payload snapshotPayload[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&payload); err != nil {
+		return nil, nil, created, fmt.Errorf("не удалось декодировать снимок: %w", err)
+	}
+	if len(payload.Keys) != len(payload.Values) {
+		return nil, nil, created, errors.New("снимок поврежден: число ключей и значений не совпадает")
+	}
+
+	values := make(map[K]V, len(payload.Keys))
+	for i, key := range payload.Keys {
+		values[key] = payload.Values[i]
+	}
+
+	return payload.Keys, values, created, nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Смещения полей заголовка снимка от начала файла.
+const (
+	snapshotVersionOffset = len(snapshotMagic)
+	snapshotCreatedOffset = snapshotVersionOffset + 2
+	snapshotLengthOffset  = snapshotCreatedOffset + 8
+)
+
+// testStore - хранилище состава кэша, которое восстанавливает заранее заданные элементы.
+type testStore struct {
+	keys     []string
+	values   map[string]string
+	restored int // Количество вызовов Restore
+}
+
+func (s *testStore) Restore(context.Context, int) ([]string, map[string]string, error) {
+	s.restored++
+	return s.keys, s.values, nil
+}
+
+func (s *testStore) Added(string)   {}
+func (s *testStore) Evicted(string) {}
+
+// newSnapshotCache создает кэш со снимком path и хранилищем store и восстанавливает его так же,
+// как сервис после готовности базы данных.
+func newSnapshotCache(t *testing.T, path string, maxAge time.Duration, store *testStore) *Cache[string, string] {
+	t.Helper()
+	cfg := CacheConfig{Size: 10, SnapshotPath: path, SnapshotMaxAge: maxAge}
+	csh := NewCacheOf[string, string]("test", cfg, store, nil, func(v string) int64 { return int64(len(v)) })
+	csh.Restore(context.Background())
+	return csh
+}
+
+// dbStore возвращает хранилище, из которого восстанавливается один элемент "db".
+func dbStore() *testStore {
+	return &testStore{keys: []string{"db"}, values: map[string]string{"db": "из базы данных"}}
+}
+
+// Снимок, сохраненный при завершении работы, загружается при запуске, и хранилище не используется.
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	csh := newSnapshotCache(t, path, time.Hour, &testStore{})
+	csh.Set("A", "a")
+	csh.Set("B", "b")
+	if err := csh.Finish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := dbStore()
+	restored := newSnapshotCache(t, path, time.Hour, store)
+
+	if store.restored != 0 {
+		t.Fatal("кэш загружен из снимка, хранилище не должно использоваться")
+	}
+	expectCached(t, restored, []string{"A", "B", "db"}, "A", "B")
+	if got, _ := restored.Get("B"); got != "b" {
+		t.Fatalf("Get(B) = %q, ожидается b", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("загруженный снимок должен быть удален: %v", err)
+	}
+}
+
+// Поврежденный, устаревший или неподдерживаемый снимок не загружается, и кэш восстанавливается из хранилища.
+func TestSnapshotFallsBackToStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(data []byte) []byte
+	}{
+		{"crc mismatch", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xFF
+			return data
+		}},
+		{"unknown version", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[snapshotVersionOffset:], snapshotVersion+1)
+			return data
+		}},
+		{"stale", func(data []byte) []byte {
+			binary.BigEndian.PutUint64(data[snapshotCreatedOffset:], uint64(time.Now().Add(-2*time.Hour).UnixNano()))
+			return data
+		}},
+		{"truncated", func(data []byte) []byte {
+			return data[:len(data)-10]
+		}},
+		{"length beyond file", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[snapshotLengthOffset:], 1<<32-1)
+			return data
+		}},
+		{"not a snapshot", func(data []byte) []byte {
+			return []byte("{}")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.snapshot")
+			if err := writeSnapshot(path, []string{"A"}, map[string]string{"A": "a"}); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.mutate(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store := dbStore()
+			csh := newSnapshotCache(t, path, time.Hour, store)
+
+			if store.restored != 1 {
+				t.Fatalf("снимок не должен загружаться, ожидается восстановление из хранилища, вызовов Restore: %d", store.restored)
+			}
+			expectCached(t, csh, []string{"A", "db"}, "db")
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ConnConfig описывает параметры подключения к NATS.
+type ConnConfig struct {
+	Servers []string // Адреса серверов NATS
+
+	NKeyFile  string // Файл с ключом NKey
+	CredsFile string // Файл учетных данных (JWT и NKey)
+
+	TLSCert string // Сертификат клиента
+	TLSKey  string // Ключ сертификата клиента
+	TLSCA   string // Корневой сертификат для проверки сервера
+
+	ReconnectWait time.Duration // Пауза между попытками переподключения
+	MaxReconnects int           // Количество попыток переподключения, -1 - без ограничения
+
+	ConnectAttempts   int           // Количество попыток первого подключения
+	ConnectBackoffMax time.Duration // Максимальная пауза между попытками первого подключения
+}
+
+// ConnStats содержит состояние подключения к NATS и счетчики его событий.
+type ConnStats struct {
+	Connected   bool   `json:"connected"`
+	URL         string `json:"url"`
+	Disconnects uint64 `json:"disconnects"`
+	Reconnects  uint64 `json:"reconnects"`
+	AsyncErrors uint64 `json:"async_errors"`
+	InMsgs      uint64 `json:"in_msgs"`
+	OutMsgs     uint64 `json:"out_msgs"`
+	InBytes     uint64 `json:"in_bytes"`
+	OutBytes    uint64 `json:"out_bytes"`
+}
+
+// connEvents считает события подключений к NATS.
+var connEvents struct {
+	disconnects atomic.Uint64
+	reconnects  atomic.Uint64
+	asyncErrors atomic.Uint64
+}
+
+// OrdersSubject возвращает канал, из которого принимаются заказы, из NATS_SUBJECT.
+func OrdersSubject() string {
+	if subject := os.Getenv("NATS_SUBJECT"); subject != "" {
+		return subject
+	}
+	return "intros"
+}
+
+// ConnConfigFromEnv получает параметры подключения к NATS из переменных окружения.
+func ConnConfigFromEnv() ConnConfig {
+	cfg := ConnConfig{
+		Servers:           []string{nats.DefaultURL},
+		NKeyFile:          os.Getenv("NATS_NKEY_FILE"),
+		CredsFile:         os.Getenv("NATS_CREDS_FILE"),
+		TLSCert:           os.Getenv("NATS_TLS_CERT"),
+		TLSKey:            os.Getenv("NATS_TLS_KEY"),
+		TLSCA:             os.Getenv("NATS_TLS_CA"),
+		ReconnectWait:     nats.DefaultReconnectWait,
+		MaxReconnects:     nats.DefaultMaxReconnect,
+		ConnectAttempts:   10,
+		ConnectBackoffMax: 30 * time.Second,
+	}
+
+	if servers := os.Getenv("NATS_SERVERS"); servers != "" {
+		cfg.Servers = cfg.Servers[:0]
+		for _, server := range strings.Split(servers, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				cfg.Servers = append(cfg.Servers, server)
+			}
+		}
+	}
+	if value, err := time.ParseDuration(os.Getenv("NATS_RECONNECT_WAIT")); err == nil && value > 0 {
+		cfg.ReconnectWait = value
+	}
+	// Отрицательное значение означает переподключение без ограничения
+	if value, err := strconv.Atoi(os.Getenv("NATS_MAX_RECONNECTS")); err == nil {
+		cfg.MaxReconnects = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_CONNECT_ATTEMPTS")); err == nil && value > 0 {
+		cfg.ConnectAttempts = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("NATS_CONNECT_BACKOFF_MAX")); err == nil && value > 0 {
+		cfg.ConnectBackoffMax = value
+	}
+
+	return cfg
+}
+
+// options возвращает параметры подключения nats.go для конфигурации cfg.
+func (cfg ConnConfig) options() ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.ReconnectWait(cfg.ReconnectWait),
+		nats.MaxReconnects(cfg.MaxReconnects),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			// Без ошибки обработчик вызывается при штатном закрытии соединения
+			if err != nil {
+				connEvents.disconnects.Add(1)
+				log.Printf("Соединение с NATS потеряно: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			connEvents.reconnects.Add(1)
+			log.Printf("Соединение с NATS восстановлено: %s", conn.ConnectedUrlRedacted())
+		}),
+		nats.ErrorHandler(func(conn *nats.Conn, sub *nats.Subscription, err error) {
+			connEvents.asyncErrors.Add(1)
+			if sub != nil {
+				log.Printf("Ошибка NATS в подписке на %s: %v", sub.Subject, err)
+			} else {
+				log.Printf("Ошибка NATS: %v", err)
+			}
+		}),
+	}
+
+	if cfg.CredsFile != "" {
+		options = append(options, nats.UserCredentials(cfg.CredsFile))
+	}
+	if cfg.NKeyFile != "" {
+		option, err := nats.NkeyOptionFromSeed(cfg.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать ключ NKey %s: %w", cfg.NKeyFile, err)
+		}
+		options = append(options, option)
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		options = append(options, nats.ClientCert(cfg.TLSCert, cfg.TLSKey))
+	}
+	if cfg.TLSCA != "" {
+		options = append(options, nats.RootCAs(cfg.TLSCA))
+	}
+
+	return options, nil
+}
+
+// Connect устанавливает соединение с NATS. Если сервер недоступен, подключение повторяется
+// ConnectAttempts раз с удвоением паузы между попытками, но не более ConnectBackoffMax.
+// Повторные попытки прерываются вместе с ctx.
+func Connect(ctx context.Context, cfg ConnConfig, options ...nats.Option) (*nats.Conn, error) {
+	base, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	options = append(base, options...)
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		conn, err := nats.Connect(strings.Join(cfg.Servers, ","), options...)
+		if err == nil {
+			log.Printf("Подключено к NATS: %s", conn.ConnectedUrlRedacted())
+			return conn, nil
+		}
+		if attempt >= cfg.ConnectAttempts {
+			return nil, err
+		}
+
+		log.Printf("Не удалось подключиться к NATS (попытка %d из %d), повтор через %v: %v", attempt, cfg.ConnectAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff = min(backoff*2, cfg.ConnectBackoffMax)
+	}
+}
+
+// connStats возвращает состояние подключения conn и счетчики его событий.
+func connStats(conn *nats.Conn) ConnStats {
+	stats := conn.Stats()
+	return ConnStats{
+		Connected:   conn.IsConnected(),
+		URL:         conn.ConnectedUrlRedacted(),
+		Disconnects: connEvents.disconnects.Load(),
+		Reconnects:  connEvents.reconnects.Load(),
+		AsyncErrors: connEvents.asyncErrors.Load(),
+		InMsgs:      stats.InMsgs,
+		OutMsgs:     stats.OutMsgs,
+		InBytes:     stats.InBytes,
+		OutBytes:    stats.OutBytes,
+	}
+}
@@ -0,0 +1,171 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Этапы обработки сообщения, на которых оно может быть отклонено.
+const (
+	StageParse    = "parse"    // Разбор JSON
+	StageValidate = "validate" // Проверка заказа
+	StagePersist  = "persist"  // Сохранение в базу данных
+)
+
+// Заголовки, которые добавляются к сообщению при отправке в канал недоставленных сообщений.
+const (
+	DeadLetterReasonHeader    = "Dlq-Reason"
+	DeadLetterStageHeader     = "Dlq-Stage"
+	DeadLetterTimestampHeader = "Dlq-Timestamp"
+	DeadLetterSubjectHeader   = "Dlq-Subject"
+)
+
+// deadLetterStream - поток JetStream, в котором хранятся недоставленные сообщения.
+const deadLetterStream = "INTROS_DLQ"
+
+// ProcessError описывает ошибку обработки сообщения и этап, на котором она произошла.
+type ProcessError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetter описывает сообщение, сохраненное в канале недоставленных сообщений.
+type DeadLetter struct {
+	Sequence uint64
+	Subject  string // Канал, из которого было получено сообщение
+	Stage    string
+	Reason   string
+	Time     time.Time
+	Header   nats.Header // Исходные заголовки сообщения
+	Data     []byte      // Исходное содержимое сообщения
+}
+
+// DeadLetterSubject возвращает канал недоставленных сообщений из NATS_DLQ_SUBJECT.
+func DeadLetterSubject() string {
+	if subject := os.Getenv("NATS_DLQ_SUBJECT"); subject != "" {
+		return subject
+	}
+	return "intros.dlq"
+}
+
+// ensureDeadLetterStream создает поток JetStream для канала недоставленных сообщений, если его еще нет.
+func ensureDeadLetterStream(js nats.JetStreamContext, subject string) error {
+	_, err := js.StreamInfo(deadLetterStream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     deadLetterStream,
+			Subjects: []string{subject},
+			Storage:  nats.FileStorage,
+		})
+	}
+	return err
+}
+
+// publishDeadLetter отправляет исходное сообщение в канал недоставленных сообщений
+// вместе с причиной, этапом и временем ошибки.
+func publishDeadLetter(js nats.JetStreamContext, subject string, msg *nats.Msg, procErr *ProcessError) error {
+	dead := nats.NewMsg(subject)
+	dead.Data = msg.Data
+	for key, values := range msg.Header {
+		dead.Header[key] = values
+	}
+	dead.Header.Set(DeadLetterReasonHeader, procErr.Err.Error())
+	dead.Header.Set(DeadLetterStageHeader, procErr.Stage)
+	dead.Header.Set(DeadLetterTimestampHeader, time.Now().UTC().Format(time.RFC3339))
+	dead.Header.Set(DeadLetterSubjectHeader, msg.Subject)
+
+	_, err := js.PublishMsg(dead)
+	return err
+}
+
+// ListDeadLetters возвращает все сообщения, сохраненные в канале недоставленных сообщений.
+func ListDeadLetters(js nats.JetStreamContext) ([]DeadLetter, error) {
+	info, err := js.StreamInfo(deadLetterStream)
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []DeadLetter
+	if info.State.Msgs == 0 {
+		return letters, nil
+	}
+
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		letter, err := GetDeadLetter(js, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return letters, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter возвращает сообщение канала недоставленных сообщений по его номеру.
+func GetDeadLetter(js nats.JetStreamContext, seq uint64) (DeadLetter, error) {
+	raw, err := js.GetMsg(deadLetterStream, seq)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	letter := DeadLetter{
+		Sequence: raw.Sequence,
+		Subject:  raw.Header.Get(DeadLetterSubjectHeader),
+		Stage:    raw.Header.Get(DeadLetterStageHeader),
+		Reason:   raw.Header.Get(DeadLetterReasonHeader),
+		Header:   nats.Header{},
+		Data:     raw.Data,
+	}
+	letter.Time, _ = time.Parse(time.RFC3339, raw.Header.Get(DeadLetterTimestampHeader))
+	for key, values := range raw.Header {
+		if !strings.HasPrefix(key, "Dlq-") {
+			letter.Header[key] = values
+		}
+	}
+
+	return letter, nil
+}
+
+// ReplayDeadLetter повторно публикует сообщение в поток исходного канала и удаляет его из канала
+// недоставленных сообщений.
+func ReplayDeadLetter(js nats.JetStreamContext, seq uint64) error {
+	letter, err := GetDeadLetter(js, seq)
+	if err != nil {
+		return err
+	}
+
+	subject := letter.Subject
+	if subject == "" {
+		subject = OrdersSubject()
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = letter.Data
+	msg.Header = letter.Header
+	if _, err := js.PublishMsg(msg); err != nil {
+		return err
+	}
+
+	if err := js.DeleteMsg(deadLetterStream, seq); err != nil {
+		log.Printf("Сообщение %d отправлено повторно, но не удалено из канала недоставленных сообщений: %v", seq, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package streaming
+
+import (
+	"WBTech_L0/internal/database"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Действия с заказом в кэше других экземпляров сервиса.
+const (
+	InvalidateEvict   = "evict"   // Удалить заказ из кэша, отправляется DeleteOrder
+	InvalidateRefresh = "refresh" // Заново загрузить заказ из базы данных, если он есть в кэше, отправляется после upsert
+)
+
+// Invalidation описывает сообщение канала сброса кэша.
+type Invalidation struct {
+	OrderUID string `json:"order_uid"`
+	Action   string `json:"action"`
+	Origin   string `json:"origin"` // APP_KEY экземпляра, изменившего заказ
+}
+
+// InvalidationSubject возвращает канал сброса кэша из NATS_INVALIDATE_SUBJECT.
+// Сообщения канала получают все экземпляры сервиса.
+func InvalidationSubject() string {
+	if subject := os.Getenv("NATS_INVALIDATE_SUBJECT"); subject != "" {
+		return subject
+	}
+	return "intros.invalidate"
+}
+
+// subscribeInvalidations подписывается на канал сброса кэша.
+// В отличие от канала заказов подписка не входит в группу: сообщение получает каждый экземпляр.
+func (s *Streaming) subscribeInvalidations() (*nats.Subscription, error) {
+	return s.conn.Subscribe(InvalidationSubject(), func(msg *nats.Msg) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Done()
+
+		var inv Invalidation
+		if err := json.Unmarshal(msg.Data, &inv); err != nil {
+			log.Printf("Некорректное сообщение сброса кэша: %v\n", err)
+			return
+		}
+		// Собственные изменения уже учтены в кэше
+		if inv.Origin == os.Getenv("APP_KEY") {
+			return
+		}
+		s.invalidate(inv)
+	})
+}
+
+// invalidate применяет сообщение сброса кэша к кэшу текущего экземпляра.
+func (s *Streaming) invalidate(inv Invalidation) {
+	switch inv.Action {
+	case InvalidateEvict:
+		s.cshObject.Delete(inv.OrderUID)
+	case InvalidateRefresh:
+		ctx, cancel := context.WithTimeout(s.ctx, s.consumer.AckWait)
+		defer cancel()
+
+		cached, err := s.cshObject.Refresh(ctx, inv.OrderUID)
+		if errors.Is(err, database.ErrNotFound) {
+			s.cshObject.Delete(inv.OrderUID)
+			return
+		}
+		if err != nil {
+			// Устаревшую версию лучше удалить, чем продолжать отдавать
+			log.Printf("Не удалось обновить заказ %s в кэше: %v\n", inv.OrderUID, err)
+			s.cshObject.Delete(inv.OrderUID)
+			return
+		}
+		if cached {
+			log.Printf("Заказ %s обновлен в кэше по сообщению от %s\n", inv.OrderUID, inv.Origin)
+		}
+	default:
+		log.Printf("Неизвестное действие сброса кэша %q для заказа %s\n", inv.Action, inv.OrderUID)
+	}
+}
+
+// DeleteOrder удаляет заказ из хранилища и из кэша и сообщает остальным экземплярам сервиса,
+// что заказ нужно удалить из их кэша (InvalidateEvict). Возвращает ошибку хранилища,
+// например database.ErrNotFound, если заказа нет.
+func (s *Streaming) DeleteOrder(ctx context.Context, orderUID string) error {
+	if err := s.cshObject.Repo.Delete(ctx, orderUID); err != nil {
+		return err
+	}
+	s.cshObject.Delete(orderUID)
+
+	if err := s.Invalidate(orderUID, InvalidateEvict); err != nil {
+		log.Printf("Не удалось отправить сообщение сброса кэша для заказа %s: %v\n", orderUID, err)
+	}
+	return nil
+}
+
+// Invalidate сообщает остальным экземплярам сервиса, что заказ orderUID изменился
+// и его нужно удалить из кэша (InvalidateEvict) или загрузить заново (InvalidateRefresh).
+func (s *Streaming) Invalidate(orderUID string, action string) error {
+	data, err := json.Marshal(Invalidation{OrderUID: orderUID, Action: action, Origin: os.Getenv("APP_KEY")})
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(InvalidationSubject(), data)
+}
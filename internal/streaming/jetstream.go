@@ -0,0 +1,156 @@
+package streaming
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ordersStream - поток JetStream, в котором хранятся заказы из канала OrdersSubject.
+const ordersStream = "INTROS"
+
+// ConsumerConfig описывает параметры постоянного (durable) подписчика JetStream.
+type ConsumerConfig struct {
+	Durable    string        // Имя подписчика, под которым сервер запоминает подтвержденные сообщения
+	QueueGroup string        // Группа, между экземплярами которой распределяются сообщения
+	MaxDeliver int           // Максимальное количество попыток доставки сообщения
+	AckWait    time.Duration // Время на обработку сообщения до повторной доставки
+	NakDelay   time.Duration // Задержка повторной доставки после временной ошибки базы данных
+	Workers    int           // Количество обработчиков сообщений
+	QueueSize  int           // Размер очереди сообщений, ожидающих обработчика
+	BatchSize  int           // Максимальное количество заказов, сохраняемых одной транзакцией
+	BatchWait  time.Duration // Максимальное время набора пачки
+
+	// Максимальное количество неподтвержденных сообщений подписчика. Подписчик общий для всех
+	// экземпляров сервиса, поэтому ограничение действует на все экземпляры вместе
+	MaxAckPending int
+}
+
+// ConsumerConfigFromEnv получает параметры подписчика из переменных окружения
+// NATS_DURABLE, NATS_QUEUE_GROUP, NATS_MAX_DELIVER, NATS_ACK_WAIT, NATS_NAK_DELAY, NATS_WORKERS, NATS_WORKER_QUEUE,
+// NATS_BATCH_SIZE, NATS_BATCH_WAIT и NATS_MAX_ACK_PENDING.
+func ConsumerConfigFromEnv() ConsumerConfig {
+	cfg := ConsumerConfig{
+		Durable:       os.Getenv("NATS_DURABLE"),
+		QueueGroup:    os.Getenv("NATS_QUEUE_GROUP"),
+		MaxDeliver:    5,
+		AckWait:       30 * time.Second,
+		NakDelay:      5 * time.Second,
+		Workers:       4,
+		QueueSize:     100,
+		BatchSize:     1,
+		BatchWait:     100 * time.Millisecond,
+		MaxAckPending: 1000,
+	}
+	if cfg.Durable == "" {
+		cfg.Durable = "orders-service"
+	}
+	if cfg.QueueGroup == "" {
+		cfg.QueueGroup = cfg.Durable
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_MAX_DELIVER")); err == nil && value > 0 {
+		cfg.MaxDeliver = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("NATS_ACK_WAIT")); err == nil && value > 0 {
+		cfg.AckWait = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("NATS_NAK_DELAY")); err == nil && value > 0 {
+		cfg.NakDelay = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_WORKERS")); err == nil && value > 0 {
+		cfg.Workers = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_WORKER_QUEUE")); err == nil && value > 0 {
+		cfg.QueueSize = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_BATCH_SIZE")); err == nil && value > 0 {
+		cfg.BatchSize = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("NATS_BATCH_WAIT")); err == nil && value > 0 {
+		cfg.BatchWait = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("NATS_MAX_ACK_PENDING")); err == nil && value > 0 {
+		cfg.MaxAckPending = value
+	}
+	return cfg
+}
+
+// EnsureOrdersStream создает поток JetStream для канала заказов, если его еще нет.
+// Сообщения, опубликованные в канал, сохраняются и доставляются, даже если сервис в это время не запущен.
+func EnsureOrdersStream(js nats.JetStreamContext) error {
+	_, err := js.StreamInfo(ordersStream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     ordersStream,
+			Subjects: []string{OrdersSubject()},
+			Storage:  nats.FileStorage,
+		})
+	}
+	return err
+}
+
+// consumerConfig возвращает параметры постоянного подписчика потока заказов для cfg.
+func consumerConfig(cfg ConsumerConfig) *nats.ConsumerConfig {
+	return &nats.ConsumerConfig{
+		Durable:       cfg.Durable,
+		DeliverGroup:  cfg.QueueGroup,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       cfg.AckWait,
+		MaxDeliver:    cfg.MaxDeliver,
+		MaxAckPending: cfg.MaxAckPending,
+		FilterSubject: OrdersSubject(),
+	}
+}
+
+// ensureConsumer создает постоянного подписчика потока заказов, если его еще нет, и возвращает его состояние.
+// Подписчик создается отдельно от подписки, чтобы Drain при завершении работы не удалял его
+// вместе с позицией чтения. Все экземпляры сервиса используют одного подписчика, а сообщения
+// распределяются между ними через группу QueueGroup. Параметры существующего подписчика
+// не меняются, чтобы экземпляры с разной конфигурацией не перезаписывали их друг у друга:
+// их обновляет команда consumer update (UpdateConsumer).
+func ensureConsumer(js nats.JetStreamContext, cfg ConsumerConfig) (*nats.ConsumerInfo, error) {
+	info, err := js.ConsumerInfo(ordersStream, cfg.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		consumer := consumerConfig(cfg)
+		consumer.DeliverSubject = nats.NewInbox()
+		return js.AddConsumer(ordersStream, consumer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if current := info.Config; current.AckWait != cfg.AckWait || current.MaxDeliver != cfg.MaxDeliver || current.MaxAckPending != cfg.MaxAckPending {
+		log.Printf("Параметры подписчика %s отличаются от конфигурации экземпляра, используются параметры подписчика "+
+			"(ack wait %v, max deliver %d, max ack pending %d); для обновления выполните команду consumer update\n",
+			cfg.Durable, current.AckWait, current.MaxDeliver, current.MaxAckPending)
+	}
+	return info, nil
+}
+
+// ConsumerInfo возвращает состояние постоянного подписчика потока заказов с параметрами cfg.
+func ConsumerInfo(js nats.JetStreamContext, cfg ConsumerConfig) (*nats.ConsumerInfo, error) {
+	return js.ConsumerInfo(ordersStream, cfg.Durable)
+}
+
+// UpdateConsumer обновляет параметры постоянного подписчика потока заказов по cfg
+// или создает его, если его еще нет. Канал доставки существующего подписчика не меняется.
+func UpdateConsumer(js nats.JetStreamContext, cfg ConsumerConfig) (*nats.ConsumerInfo, error) {
+	consumer := consumerConfig(cfg)
+
+	info, err := js.ConsumerInfo(ordersStream, cfg.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		consumer.DeliverSubject = nats.NewInbox()
+		return js.AddConsumer(ordersStream, consumer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.DeliverSubject = info.Config.DeliverSubject
+	return js.UpdateConsumer(ordersStream, consumer)
+}
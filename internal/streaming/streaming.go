@@ -2,34 +2,127 @@ package streaming
 
 import (
 	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/validation"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/nats-io/nats.go"
 	"log"
+	"sync"
+
+	"github.com/nats-io/nats.go"
 )
 
-// Streaming представляет собой структуру для обработки данных, полученных через NATS Streaming.
+// Заголовок сообщения, задающий режим сохранения заказа.
+const (
+	IngestModeHeader = "Ingest-Mode"
+	IngestModeUpsert = "upsert" // Заменить уже сохраненный заказ новой версией
+)
+
+// Streaming представляет собой структуру для обработки данных, полученных через NATS JetStream.
 type Streaming struct {
-	dbObject *database.DB
+	cshObject *database.OrderCache
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+	consumer  ConsumerConfig
+	sub       *nats.Subscription // Подписка на канал заказов
+	pool      *workerPool        // Обработчики сообщений канала заказов
+	dlq       string             // Канал недоставленных сообщений
+	dlqReady  bool               // Создан ли поток JetStream для недоставленных сообщений
+	inFlight  sync.WaitGroup     // Обрабатываемые в данный момент сообщения
+	closed    chan struct{}      // Закрывается после закрытия соединения с NATS
+
+	// ctx передается в операции с базой данных и отменяется, если завершение работы не уложилось в срок
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewStream создает новое соединение с NATS Streaming и устанавливает обработчики подписки.
-func NewStream(dbInstance *database.DB) (stream *nats.Conn) {
-	stream, err := nats.Connect(nats.DefaultURL)
+// Полученные заказы сохраняются в хранилище csh.Repo и помещаются в кэш csh.
+// Подключение к NATS прерывается вместе с ctx.
+func NewStream(ctx context.Context, csh *database.OrderCache) (*Streaming, error) {
+	s := &Streaming{
+		cshObject: csh,
+		consumer:  ConsumerConfigFromEnv(),
+		dlq:       DeadLetterSubject(),
+		closed:    make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	conn, err := Connect(ctx, ConnConfigFromEnv(), nats.ClosedHandler(func(*nats.Conn) {
+		close(s.closed)
+	}))
 	if err != nil {
-		log.Fatalf("Ошибка при подключении к NATS: %v", err)
+		s.cancel()
+		return nil, fmt.Errorf("ошибка при подключении к NATS: %w", err)
 	}
+	s.conn = conn
 
-	NewSubscriber(dbInstance, stream)
+	if err := s.subscribe(); err != nil {
+		s.cancel()
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
 
-	return stream
+// subscribe создает потоки и подписчика JetStream и подписывается на канал заказов и канал сброса кэша.
+func (s *Streaming) subscribe() error {
+	// Заказы хранятся в JetStream, поэтому сообщения, опубликованные во время простоя сервиса, не теряются
+	var err error
+	s.js, err = s.conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("JetStream недоступен: %w", err)
+	}
+	if err := EnsureOrdersStream(s.js); err != nil {
+		return fmt.Errorf("не удалось создать поток %s (запущен ли nats-server с -js?): %w", ordersStream, err)
+	}
+	info, err := ensureConsumer(s.js, s.consumer)
+	if err != nil {
+		return fmt.Errorf("не удалось создать подписчика %s: %w", s.consumer.Durable, err)
+	}
+	// Повторная доставка определяется параметрами подписчика на сервере, даже если они отличаются от конфигурации экземпляра
+	s.consumer.AckWait, s.consumer.MaxDeliver = info.Config.AckWait, info.Config.MaxDeliver
+
+	// Недоставленные сообщения хранятся в JetStream, чтобы их можно было просмотреть и отправить повторно
+	if err := ensureDeadLetterStream(s.js, s.dlq); err != nil {
+		log.Printf("Предупреждение: поток недоставленных сообщений недоступен: %v", err)
+	} else {
+		s.dlqReady = true
+	}
+
+	s.pool = newWorkerPool(s.consumer.Workers, s.consumer.QueueSize, s.consumer.BatchSize, s.consumer.BatchWait, s.processBatch)
+	if s.sub, err = s.NewSubscriber(); err != nil {
+		s.pool.close()
+		return fmt.Errorf("ошибка при подписке на канал NATS: %w", err)
+	}
+	if _, err := s.subscribeInvalidations(); err != nil {
+		s.sub.Unsubscribe()
+		s.pool.close()
+		return fmt.Errorf("ошибка при подписке на канал сброса кэша: %w", err)
+	}
+	return nil
 }
 
-// NewSubscriber устанавливает подписку на канал "intros" в NATS Streaming и связывает обработчик.
-func NewSubscriber(dbInstance *database.DB, stream *nats.Conn) (*nats.Subscription, error) {
-	subscription, err := stream.Subscribe("intros", func(msg *nats.Msg) {
-		SubscribeReceiver(dbInstance, msg)
-	})
+// NewSubscriber подписывается на канал заказов OrdersSubject от имени постоянного подписчика и связывает обработчик.
+// Подписка входит в группу QueueGroup, поэтому каждый заказ обрабатывает только один экземпляр сервиса.
+// Сообщения передаются в пул обработчиков. Если очередь пула заполнена, обработчик подписки ждет
+// освобождения места и продлевает срок подтверждения сообщения через InProgress: возврат в NATS
+// расходовал бы попытки доставки, и под постоянной нагрузкой заказ мог бы исчерпать MaxDeliver,
+// так и не попав в обработку. Если пул остановлен, сообщение возвращается в NATS.
+// Сообщение подтверждается только после сохранения заказа в базе данных.
+func (s *Streaming) NewSubscriber() (*nats.Subscription, error) {
+	subscription, err := s.js.QueueSubscribe(OrdersSubject(), s.consumer.QueueGroup, func(msg *nats.Msg) {
+		// Срок продлевается заранее, не дожидаясь истечения AckWait
+		wait := func() {
+			if err := msg.InProgress(); err != nil {
+				log.Printf("Не удалось продлить срок подтверждения сообщения: %v\n", err)
+			}
+		}
+		if !s.pool.submit(msg, s.consumer.AckWait/2, wait) {
+			msg.Nak()
+		}
+	}, nats.Bind(ordersStream, s.consumer.Durable), nats.ManualAck())
 
 	if err != nil {
 		return nil, err
@@ -37,18 +130,236 @@ func NewSubscriber(dbInstance *database.DB, stream *nats.Conn) (*nats.Subscripti
 	return subscription, nil
 }
 
+// processBatch обрабатывает пачку сообщений канала заказов в одном из обработчиков пула.
+// Новые заказы пачки сохраняются в базу данных одной транзакцией. Сообщения с заголовком
+// Ingest-Mode: upsert и пачки из одного сообщения обрабатываются по одному.
+func (s *Streaming) processBatch(msgs []*nats.Msg) {
+	if len(msgs) == 1 {
+		s.process(msgs[0])
+		return
+	}
+
+	var batch []*nats.Msg
+	var orders []database.Order
+	for _, msg := range msgs {
+		if msg.Header.Get(IngestModeHeader) == IngestModeUpsert {
+			s.process(msg)
+			continue
+		}
+		order, err := decode(msg)
+		if err != nil {
+			s.handle(msg, err)
+			continue
+		}
+		batch = append(batch, msg)
+		orders = append(orders, order)
+	}
+	if len(orders) == 0 {
+		return
+	}
+
+	// Пачка должна быть сохранена до того, как NATS начнет доставлять ее сообщения повторно
+	ctx, cancel := context.WithTimeout(s.ctx, s.consumer.AckWait)
+	defer cancel()
+
+	for i, result := range s.cshObject.Repo.AddBatch(ctx, orders) {
+		if result.Err != nil {
+			log.Printf("Не удалось сохранить заказ %s: %v\n", result.OrderUID, result.Err)
+			s.handle(batch[i], &ProcessError{Stage: StagePersist, Err: result.Err})
+			continue
+		}
+		// Кэш обновляется только после успешной фиксации транзакции
+		if result.Status == database.IngestInserted {
+			s.cshObject.Set(orders[i].OrderUID, orders[i])
+		}
+		s.handle(batch[i], nil)
+	}
+}
+
+// process обрабатывает одно сообщение канала заказов.
+func (s *Streaming) process(msg *nats.Msg) {
+	// Заказ должен быть сохранен до того, как NATS начнет доставлять сообщение повторно
+	ctx, cancel := context.WithTimeout(s.ctx, s.consumer.AckWait)
+	defer cancel()
+
+	orderUID, status, err := receive(ctx, s.cshObject, msg)
+	s.handle(msg, err)
+
+	// Остальные экземпляры могли закэшировать предыдущую версию заказа
+	if err == nil && status == database.IngestUpdated {
+		if err := s.Invalidate(orderUID, InvalidateRefresh); err != nil {
+			log.Printf("Не удалось отправить сообщение сброса кэша для заказа %s: %v\n", orderUID, err)
+		}
+	}
+}
+
+// Stats возвращает состояние подключения к NATS и счетчики его событий.
+func (s *Streaming) Stats() ConnStats {
+	return connStats(s.conn)
+}
+
+// WorkerStats возвращает счетчики пула обработчиков сообщений.
+func (s *Streaming) WorkerStats() WorkerStats {
+	return s.pool.stats()
+}
+
+// Shutdown прекращает прием новых сообщений, дожидается обработки уже полученных
+// и закрывает соединение с NATS. Ожидание ограничено контекстом ctx.
+func (s *Streaming) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// Сообщения из очереди пула подтверждаются, поэтому соединение закрывается
+		// только после того, как пул обработает очередь
+		if err := s.sub.Drain(); err != nil {
+			log.Printf("Не удалось остановить подписку на канал заказов: %v", err)
+		}
+		// После ответа сервера на Flush новых сообщений подписки не будет, а Barrier вызывается,
+		// когда обработчики подписок получат все уже принятые сообщения
+		if err := s.conn.FlushWithContext(ctx); err == nil {
+			drained := make(chan struct{})
+			if err := s.conn.Barrier(func() { close(drained) }); err == nil {
+				select {
+				case <-drained:
+				case <-ctx.Done():
+				}
+			}
+		}
+		s.pool.close()
+
+		if err := s.conn.Drain(); err != nil {
+			s.conn.Close()
+		}
+		<-s.closed
+		s.inFlight.Wait()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		s.conn.Close()
+		return ctx.Err()
+	}
+}
+
+// handle подтверждает сообщение по результату обработки err.
+// Успешно обработанное сообщение подтверждается. При временной ошибке базы данных сообщение
+// доставляется повторно через NakDelay. Остальные ошибки и исчерпание попыток доставки
+// приводят к отправке сообщения в канал недоставленных сообщений.
+func (s *Streaming) handle(msg *nats.Msg, err error) {
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			log.Printf("Не удалось подтвердить сообщение: %v\n", err)
+		}
+		return
+	}
+
+	var procErr *ProcessError
+	if !errors.As(err, &procErr) {
+		procErr = &ProcessError{Stage: StagePersist, Err: err}
+	}
+
+	// Обработка прервана завершением работы: сообщение будет доставлено повторно
+	if errors.Is(procErr.Err, context.Canceled) {
+		msg.Nak()
+		return
+	}
+
+	if procErr.Stage == StagePersist && database.IsTransient(procErr.Err) {
+		delivered := uint64(1)
+		if meta, err := msg.Metadata(); err == nil {
+			delivered = meta.NumDelivered
+		}
+		if delivered < uint64(s.consumer.MaxDeliver) {
+			log.Printf("Временная ошибка (попытка %d из %d), сообщение будет доставлено повторно через %v: %v\n",
+				delivered, s.consumer.MaxDeliver, s.consumer.NakDelay, procErr)
+			msg.NakWithDelay(s.consumer.NakDelay)
+			return
+		}
+	}
+
+	if !s.deadLetter(msg, procErr) {
+		// Сообщение остается неподтвержденным и будет доставлено повторно
+		msg.NakWithDelay(s.consumer.NakDelay)
+		return
+	}
+	msg.Term()
+}
+
+// deadLetter отправляет сообщение, которое не удалось обработать, в канал недоставленных сообщений.
+// Возвращает false, если сообщение отправить не удалось.
+func (s *Streaming) deadLetter(msg *nats.Msg, procErr *ProcessError) bool {
+	if !s.dlqReady {
+		log.Printf("Не удалось отправить сообщение в %s (%v): поток недоставленных сообщений недоступен\n", s.dlq, procErr)
+		return false
+	}
+	if err := publishDeadLetter(s.js, s.dlq, msg, procErr); err != nil {
+		log.Printf("Не удалось отправить сообщение в %s (%v): %v\n", s.dlq, procErr, err)
+		return false
+	}
+	log.Printf("Сообщение отправлено в %s: %v\n", s.dlq, procErr)
+	return true
+}
+
 // SubscribeReceiver обрабатывает сообщение, полученное из NATS Streaming, и добавляет информацию о заказе в базу данных.
-func SubscribeReceiver(dbInstance *database.DB, msg *nats.Msg) {
+// Успешно сохраненный заказ помещается в кэш, его order_uid записывается в wb_scheme.cache.
+// Если сообщение не удалось обработать, возвращается *ProcessError с этапом, на котором произошла ошибка.
+// Операции с базой данных выполняются в рамках ctx.
+func SubscribeReceiver(ctx context.Context, csh *database.OrderCache, msg *nats.Msg) error {
+	_, _, err := receive(ctx, csh, msg)
+	return err
+}
+
+// decode разбирает и проверяет заказ из сообщения.
+func decode(msg *nats.Msg) (database.Order, error) {
 	var orderData database.Order
 
 	err := json.Unmarshal([]byte(msg.Data), &orderData)
 
 	if err != nil {
-		fmt.Printf("Ошибка при разборе JSON: %v\n", err)
-		return
+		log.Printf("Ошибка при разборе JSON: %v\n", err)
+		return orderData, &ProcessError{Stage: StageParse, Err: err}
+	}
+
+	// Некорректные заказы не передаются в базу данных
+	if violations := validation.Validate(orderData); violations != nil {
+		details, _ := json.Marshal(violations)
+		log.Printf("Заказ %q отклонен: %s\n", orderData.OrderUID, details)
+		return orderData, &ProcessError{Stage: StageValidate, Err: violations}
+	}
+
+	return orderData, nil
+}
+
+// receive выполняет обработку сообщения для SubscribeReceiver и возвращает order_uid заказа и результат его сохранения.
+func receive(ctx context.Context, csh *database.OrderCache, msg *nats.Msg) (string, database.IngestStatus, error) {
+	orderData, err := decode(msg)
+	if err != nil {
+		return orderData.OrderUID, database.IngestInserted, err
+	}
+
+	// Новая версия уже сохраненного заказа публикуется с заголовком Ingest-Mode: upsert
+	var status database.IngestStatus
+	if msg.Header.Get(IngestModeHeader) == IngestModeUpsert {
+		status, err = csh.Repo.Upsert(ctx, orderData)
+	} else {
+		status, err = csh.Repo.Add(ctx, orderData)
+	}
+	if err != nil {
+		log.Printf("Не удалось сохранить заказ %s: %v\n", orderData.OrderUID, err)
+		return orderData.OrderUID, status, &ProcessError{Stage: StagePersist, Err: err}
+	}
+
+	if status == database.IngestDuplicate {
+		log.Printf("Заказ %s уже был получен ранее, сообщение пропущено\n", orderData.OrderUID)
+		return orderData.OrderUID, status, nil
 	}
 
-	dbInstance.AddOrderInfo(orderData)
+	// Кэш обновляется только после успешной фиксации транзакции
+	csh.Set(orderData.OrderUID, orderData)
 
-	fmt.Println(orderData.OrderUID)
+	return orderData.OrderUID, status, nil
 }
@@ -0,0 +1,536 @@
+package streaming
+
+import (
+	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/database/repotest"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// TestMain отключает журнал сервиса, который пишет строку на каждое сообщение.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// startJetStream запускает сервер NATS с JetStream в процессе теста и настраивает сервис на работу с ним.
+func startJetStream(t *testing.T) {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("не удалось создать сервер NATS: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("сервер NATS не запустился")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	t.Setenv("NATS_SERVERS", srv.ClientURL())
+	t.Setenv("NATS_CONNECT_ATTEMPTS", "1")
+	t.Setenv("NATS_MAX_DELIVER", "3")
+	t.Setenv("NATS_ACK_WAIT", "5s")
+	t.Setenv("NATS_NAK_DELAY", "200ms")
+	t.Setenv("NATS_WORKERS", "1")
+	t.Setenv("NATS_BATCH_SIZE", "1")
+	t.Setenv("CACHE_SIZE", "10")
+	t.Setenv("CACHE_SNAPSHOT_PATH", "")
+	t.Setenv("APP_KEY", "test")
+}
+
+// newTestStream запускает получение заказов в хранилище repo и останавливает его по завершении теста.
+func newTestStream(t *testing.T, repo database.OrderRepository) *Streaming {
+	t.Helper()
+
+	s, err := NewStream(context.Background(), database.NewCache(repo, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s
+}
+
+// scriptedRepository хранит заказы в памяти, а первые failures вызовов Add завершает временной ошибкой.
+// Если задан gate, Add ждет его закрытия перед сохранением.
+type scriptedRepository struct {
+	*database.MemoryRepository
+
+	mutex    sync.Mutex
+	failures int
+	attempts []time.Time
+	gate     chan struct{}
+}
+
+func newScriptedRepository(failures int) *scriptedRepository {
+	return &scriptedRepository{MemoryRepository: database.NewMemoryRepository(), failures: failures}
+}
+
+func (r *scriptedRepository) Add(ctx context.Context, order database.Order) (database.IngestStatus, error) {
+	r.mutex.Lock()
+	r.attempts = append(r.attempts, time.Now())
+	fail := r.failures > 0
+	if fail {
+		r.failures--
+	}
+	r.mutex.Unlock()
+
+	if fail {
+		return database.IngestInserted, fmt.Errorf("%w: соединение потеряно", database.ErrUnavailable)
+	}
+	if r.gate != nil {
+		select {
+		case <-r.gate:
+		case <-ctx.Done():
+			return database.IngestInserted, ctx.Err()
+		}
+	}
+	return r.MemoryRepository.Add(ctx, order)
+}
+
+// Attempts возвращает время каждого вызова Add.
+func (r *scriptedRepository) Attempts() []time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]time.Time(nil), r.attempts...)
+}
+
+// publish отправляет заказ в канал заказов.
+func publish(t *testing.T, s *Streaming, order database.Order) {
+	t.Helper()
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.js.Publish(OrdersSubject(), data); err != nil {
+		t.Fatalf("не удалось опубликовать заказ: %v", err)
+	}
+}
+
+// consumerInfo возвращает состояние постоянного подписчика сервиса.
+func consumerInfo(t *testing.T, s *Streaming) *nats.ConsumerInfo {
+	t.Helper()
+	info, err := s.js.ConsumerInfo(ordersStream, s.consumer.Durable)
+	if err != nil {
+		t.Fatalf("не удалось получить состояние подписчика: %v", err)
+	}
+	return info
+}
+
+// eventually ждет выполнения условия не дольше timeout.
+func eventually(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Сообщение подтверждается только после того, как заказ сохранен.
+func TestOrderAckedAfterCommit(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(0)
+	repo.gate = make(chan struct{})
+	s := newTestStream(t, repo)
+
+	order := repotest.Order("ack-after-commit")
+	publish(t, s, order)
+
+	eventually(t, 5*time.Second, "начало сохранения заказа", func() bool { return len(repo.Attempts()) == 1 })
+	if info := consumerInfo(t, s); info.NumAckPending != 1 || info.AckFloor.Consumer != 0 {
+		t.Fatalf("до сохранения заказа сообщение не должно быть подтверждено: ack pending %d, ack floor %d",
+			info.NumAckPending, info.AckFloor.Consumer)
+	}
+
+	close(repo.gate)
+	eventually(t, 5*time.Second, "подтверждение сообщения", func() bool {
+		info := consumerInfo(t, s)
+		return info.NumAckPending == 0 && info.AckFloor.Consumer == 1
+	})
+
+	if _, err := repo.Get(context.Background(), order.OrderUID); err != nil {
+		t.Fatalf("подтвержденный заказ не сохранен: %v", err)
+	}
+	if _, ok := s.cshObject.Get(order.OrderUID); !ok {
+		t.Fatal("сохраненный заказ не попал в кэш")
+	}
+}
+
+// При временной ошибке базы данных сообщение доставляется повторно не раньше NakDelay.
+func TestTransientErrorRedeliveredWithDelay(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(1)
+	s := newTestStream(t, repo)
+
+	order := repotest.Order("transient-error")
+	publish(t, s, order)
+
+	eventually(t, 5*time.Second, "сохранение заказа после повторной доставки", func() bool {
+		_, err := repo.Get(context.Background(), order.OrderUID)
+		return err == nil
+	})
+
+	attempts := repo.Attempts()
+	if len(attempts) != 2 {
+		t.Fatalf("попыток сохранения: %d, ожидается 2", len(attempts))
+	}
+	if delay := attempts[1].Sub(attempts[0]); delay < s.consumer.NakDelay {
+		t.Fatalf("повторная доставка через %v, ожидается не раньше %v", delay, s.consumer.NakDelay)
+	}
+
+	eventually(t, 5*time.Second, "подтверждение сообщения", func() bool {
+		return consumerInfo(t, s).NumAckPending == 0
+	})
+	if letters, err := ListDeadLetters(s.js); err != nil || len(letters) != 0 {
+		t.Fatalf("сообщение не должно попасть в %s: %v, %v", s.dlq, letters, err)
+	}
+}
+
+// После MaxDeliver неудачных попыток сообщение отправляется в канал недоставленных сообщений.
+func TestDeadLetterAfterMaxDeliver(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(100)
+	s := newTestStream(t, repo)
+
+	order := repotest.Order("dead-letter")
+	publish(t, s, order)
+
+	var letters []DeadLetter
+	eventually(t, 5*time.Second, "сообщение в канале недоставленных сообщений", func() bool {
+		var err error
+		letters, err = ListDeadLetters(s.js)
+		return err == nil && len(letters) == 1
+	})
+
+	if attempts := len(repo.Attempts()); attempts != s.consumer.MaxDeliver {
+		t.Fatalf("попыток сохранения: %d, ожидается MaxDeliver = %d", attempts, s.consumer.MaxDeliver)
+	}
+
+	letter := letters[0]
+	if letter.Stage != StagePersist || letter.Subject != OrdersSubject() {
+		t.Fatalf("недоставленное сообщение: этап %q, канал %q; ожидаются %q и %q",
+			letter.Stage, letter.Subject, StagePersist, OrdersSubject())
+	}
+	var got database.Order
+	if err := json.Unmarshal(letter.Data, &got); err != nil || got.OrderUID != order.OrderUID {
+		t.Fatalf("в канал недоставленных сообщений попал другой заказ: %s, %v", letter.Data, err)
+	}
+
+	eventually(t, 5*time.Second, "завершение доставки сообщения", func() bool {
+		return consumerInfo(t, s).NumAckPending == 0
+	})
+	if _, err := repo.Get(context.Background(), order.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("заказ не должен быть сохранен: %v", err)
+	}
+}
+
+// Сообщения, которые не удалось разобрать или которые не прошли проверку, сразу отправляются
+// в канал недоставленных сообщений с этапом и причиной ошибки и не передаются в хранилище.
+func TestDeadLetterParseAndValidate(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(0)
+	s := newTestStream(t, repo)
+
+	if _, err := s.js.Publish(OrdersSubject(), []byte(`{"order_uid": `)); err != nil {
+		t.Fatalf("не удалось опубликовать сообщение: %v", err)
+	}
+	invalid := repotest.Order("invalid-currency")
+	invalid.Payment.Currency = "usd"
+	publish(t, s, invalid)
+
+	var letters []DeadLetter
+	eventually(t, 5*time.Second, "два сообщения в канале недоставленных сообщений", func() bool {
+		var err error
+		letters, err = ListDeadLetters(s.js)
+		return err == nil && len(letters) == 2
+	})
+
+	parsed, validated := letters[0], letters[1]
+	if parsed.Stage != StageParse || !strings.Contains(parsed.Reason, "JSON") {
+		t.Fatalf("некорректный JSON: этап %q, причина %q; ожидается этап %q и ошибка разбора JSON",
+			parsed.Stage, parsed.Reason, StageParse)
+	}
+	if string(parsed.Data) != `{"order_uid": ` {
+		t.Fatalf("в канал недоставленных сообщений попало другое содержимое: %s", parsed.Data)
+	}
+	if validated.Stage != StageValidate || !strings.Contains(validated.Reason, "payment.currency") {
+		t.Fatalf("некорректный заказ: этап %q, причина %q; ожидается этап %q и нарушение payment.currency",
+			validated.Stage, validated.Reason, StageValidate)
+	}
+	for _, letter := range letters {
+		if letter.Subject != OrdersSubject() || letter.Time.IsZero() {
+			t.Fatalf("недоставленное сообщение: канал %q, время %v; ожидается канал %q и время ошибки",
+				letter.Subject, letter.Time, OrdersSubject())
+		}
+	}
+
+	eventually(t, 5*time.Second, "завершение доставки сообщений", func() bool {
+		return consumerInfo(t, s).NumAckPending == 0
+	})
+	if attempts := len(repo.Attempts()); attempts != 0 {
+		t.Fatalf("попыток сохранения: %d, некорректные сообщения не должны передаваться в хранилище", attempts)
+	}
+}
+
+// Повторно отправленное сообщение публикуется в канал заказов с исходными заголовками
+// и удаляется из канала недоставленных сообщений.
+func TestReplayDeadLetter(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(3)
+	s := newTestStream(t, repo)
+
+	order := repotest.Order("replayed")
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := nats.NewMsg(OrdersSubject())
+	msg.Data = data
+	msg.Header.Set("X-Trace-Id", "trace-1")
+	if _, err := s.js.PublishMsg(msg); err != nil {
+		t.Fatalf("не удалось опубликовать заказ: %v", err)
+	}
+
+	var letters []DeadLetter
+	eventually(t, 5*time.Second, "сообщение в канале недоставленных сообщений", func() bool {
+		letters, err = ListDeadLetters(s.js)
+		return err == nil && len(letters) == 1
+	})
+
+	replayed, err := s.conn.SubscribeSync(OrdersSubject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplayDeadLetter(s.js, letters[0].Sequence); err != nil {
+		t.Fatalf("ReplayDeadLetter: %v", err)
+	}
+
+	got, err := replayed.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("сообщение не вернулось в канал заказов: %v", err)
+	}
+	if string(got.Data) != string(data) || got.Header.Get("X-Trace-Id") != "trace-1" {
+		t.Fatalf("вернулось сообщение %s с заголовками %v, ожидается исходное", got.Data, got.Header)
+	}
+	if got.Header.Get(DeadLetterStageHeader) != "" || got.Header.Get(DeadLetterReasonHeader) != "" {
+		t.Fatalf("заголовки канала недоставленных сообщений не должны попадать в канал заказов: %v", got.Header)
+	}
+
+	if letters, err := ListDeadLetters(s.js); err != nil || len(letters) != 0 {
+		t.Fatalf("сообщение должно быть удалено из %s: %v, %v", s.dlq, letters, err)
+	}
+	eventually(t, 5*time.Second, "сохранение повторно отправленного заказа", func() bool {
+		_, err := repo.Get(context.Background(), order.OrderUID)
+		return err == nil
+	})
+}
+
+// Удаление заказа через сервис убирает его из хранилища и кэша и рассылает остальным экземплярам evict.
+func TestDeleteOrderBroadcastsEvict(t *testing.T) {
+	startJetStream(t)
+	repo := newScriptedRepository(0)
+	s := newTestStream(t, repo)
+
+	order := repotest.Order("deleted")
+	if _, err := repo.Add(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+	s.cshObject.Set(order.OrderUID, order)
+
+	invalidations, err := s.conn.SubscribeSync(InvalidationSubject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteOrder(context.Background(), order.OrderUID); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+
+	msg, err := invalidations.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("сообщение сброса кэша не отправлено: %v", err)
+	}
+	var inv Invalidation
+	if err := json.Unmarshal(msg.Data, &inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv != (Invalidation{OrderUID: order.OrderUID, Action: InvalidateEvict, Origin: "test"}) {
+		t.Fatalf("сообщение сброса кэша %+v, ожидается evict заказа %s", inv, order.OrderUID)
+	}
+
+	if _, err := repo.Get(context.Background(), order.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("заказ должен быть удален из хранилища: %v", err)
+	}
+	if _, ok := s.cshObject.Peek(order.OrderUID); ok {
+		t.Fatal("заказ должен быть удален из кэша")
+	}
+	if err := s.DeleteOrder(context.Background(), order.OrderUID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("повторное удаление: ожидается ErrNotFound, получено %v", err)
+	}
+}
+
+// Сообщение evict от другого экземпляра удаляет заказ из кэша, собственные сообщения пропускаются.
+func TestEvictFromOtherInstance(t *testing.T) {
+	startJetStream(t)
+	s := newTestStream(t, newScriptedRepository(0))
+
+	for _, uid := range []string{"own", "foreign"} {
+		s.cshObject.Set(uid, repotest.Order(uid))
+	}
+	for _, inv := range []Invalidation{
+		{OrderUID: "own", Action: InvalidateEvict, Origin: "test"},
+		{OrderUID: "foreign", Action: InvalidateEvict, Origin: "other"},
+	} {
+		data, _ := json.Marshal(inv)
+		if err := s.conn.Publish(InvalidationSubject(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eventually(t, 5*time.Second, "удаление заказа из кэша по сообщению другого экземпляра", func() bool {
+		_, ok := s.cshObject.Peek("foreign")
+		return !ok
+	})
+	// Сообщения обрабатываются по порядку, поэтому собственное сообщение уже пропущено
+	if _, ok := s.cshObject.Peek("own"); !ok {
+		t.Fatal("собственное сообщение сброса кэша не должно удалять заказ")
+	}
+}
+
+// blockedPool создает пул из одного обработчика с очередью на одно сообщение и заполняет его:
+// первое сообщение ждет в обработчике закрытия gate, второе - в очереди.
+func blockedPool(t *testing.T, gate chan struct{}) *workerPool {
+	t.Helper()
+	pool := newWorkerPool(1, 1, 1, time.Millisecond, func([]*nats.Msg) { <-gate })
+	noop := func() {}
+	pool.submit(&nats.Msg{}, time.Second, noop)
+	eventually(t, 5*time.Second, "начало обработки первого сообщения", func() bool { return pool.stats().QueueDepth == 0 })
+	pool.submit(&nats.Msg{}, time.Second, noop)
+	return pool
+}
+
+// Если очередь пула заполнена, сообщение ждет освобождения места, а срок его подтверждения
+// продлевается: возврат в NATS расходовал бы попытки доставки.
+func TestWorkerPoolWaitsForFreeSlot(t *testing.T) {
+	gate := make(chan struct{})
+	pool := blockedPool(t, gate)
+
+	var extended atomic.Int32
+	submitted := make(chan bool)
+	go func() {
+		submitted <- pool.submit(&nats.Msg{}, 10*time.Millisecond, func() { extended.Add(1) })
+	}()
+
+	eventually(t, 5*time.Second, "продление срока подтверждения", func() bool { return extended.Load() >= 2 })
+	close(gate)
+	if !<-submitted {
+		t.Fatal("сообщение должно попасть в очередь после освобождения места")
+	}
+	pool.close()
+
+	if stats := pool.stats(); stats.Processed != 3 || stats.Waited != 1 || stats.Rejected != 0 {
+		t.Fatalf("processed %d, waited %d, rejected %d; ожидается 3, 1 и 0", stats.Processed, stats.Waited, stats.Rejected)
+	}
+}
+
+// Остановка пула прерывает ожидание места в очереди, и сообщение возвращается в NATS.
+func TestWorkerPoolCloseStopsWaiting(t *testing.T) {
+	gate := make(chan struct{})
+	pool := blockedPool(t, gate)
+
+	submitted := make(chan bool)
+	go func() {
+		submitted <- pool.submit(&nats.Msg{}, time.Second, func() {})
+	}()
+	eventually(t, 5*time.Second, "ожидание места в очереди", func() bool { return pool.stats().Waited == 1 })
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		pool.close()
+	}()
+	if <-submitted {
+		t.Fatal("остановленный пул не должен принимать сообщение")
+	}
+	close(gate)
+	<-closed
+
+	if stats := pool.stats(); stats.Processed != 2 || stats.Rejected != 1 {
+		t.Fatalf("processed %d, rejected %d; ожидается 2 и 1", stats.Processed, stats.Rejected)
+	}
+}
+
+// Экземпляр с другой конфигурацией не меняет параметры общего подписчика, а работает с ними;
+// параметры меняет только UpdateConsumer.
+func TestConsumerSettingsNotOverwrittenByReplica(t *testing.T) {
+	startJetStream(t)
+	t.Setenv("NATS_MAX_ACK_PENDING", "500")
+	first := newTestStream(t, newScriptedRepository(0))
+
+	t.Setenv("NATS_ACK_WAIT", "7s")
+	t.Setenv("NATS_MAX_DELIVER", "9")
+	t.Setenv("NATS_MAX_ACK_PENDING", "20")
+	t.Setenv("APP_KEY", "replica")
+	replica := newTestStream(t, newScriptedRepository(0))
+
+	info := consumerInfo(t, first)
+	if info.Config.AckWait != 5*time.Second || info.Config.MaxDeliver != 3 || info.Config.MaxAckPending != 500 {
+		t.Fatalf("параметры подписчика изменены вторым экземпляром: ack wait %v, max deliver %d, max ack pending %d",
+			info.Config.AckWait, info.Config.MaxDeliver, info.Config.MaxAckPending)
+	}
+	if replica.consumer.AckWait != 5*time.Second || replica.consumer.MaxDeliver != 3 {
+		t.Fatalf("второй экземпляр должен работать с параметрами подписчика: ack wait %v, max deliver %d",
+			replica.consumer.AckWait, replica.consumer.MaxDeliver)
+	}
+
+	info, err := UpdateConsumer(replica.js, ConsumerConfigFromEnv())
+	if err != nil {
+		t.Fatalf("UpdateConsumer: %v", err)
+	}
+	if info.Config.AckWait != 7*time.Second || info.Config.MaxDeliver != 9 || info.Config.MaxAckPending != 20 {
+		t.Fatalf("UpdateConsumer не применил параметры: ack wait %v, max deliver %d, max ack pending %d",
+			info.Config.AckWait, info.Config.MaxDeliver, info.Config.MaxAckPending)
+	}
+}
+
+// Повторные попытки подключения к недоступному серверу NATS прерываются вместе с ctx.
+func TestConnectStopsRetryingOnCancel(t *testing.T) {
+	cfg := ConnConfig{Servers: []string{"nats://127.0.0.1:1"}, ConnectAttempts: 100, ConnectBackoffMax: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	conn, err := Connect(ctx, cfg)
+	if conn != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Connect = %v, %v; ожидается context.DeadlineExceeded", conn, err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("Connect завершился через %v, ожидание между попытками должно прерываться вместе с ctx", elapsed)
+	}
+}
@@ -0,0 +1,169 @@
+package streaming
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// WorkerStats содержит счетчики пула обработчиков сообщений.
+type WorkerStats struct {
+	Workers       int     `json:"workers"`
+	QueueDepth    int     `json:"queue_depth"`
+	QueueCapacity int     `json:"queue_capacity"`
+	Processed     uint64  `json:"processed"`
+	Waited        uint64  `json:"waited"`   // Сообщения, ожидавшие освобождения места в очереди
+	Rejected      uint64  `json:"rejected"` // Сообщения, возвращенные в NATS из-за остановки пула
+	AvgLatencyMs  float64 `json:"avg_latency_ms"`
+	MaxLatencyMs  float64 `json:"max_latency_ms"`
+}
+
+// workerPool обрабатывает сообщения в workers горутинах пачками до batchSize сообщений.
+// Подписка только ставит сообщение в очередь ограниченного размера и не ждет обработки,
+// поэтому медленная транзакция PostgreSQL не останавливает получение сообщений, пока в очереди есть место.
+type workerPool struct {
+	jobs      chan *nats.Msg
+	workers   int
+	batchSize int
+	batchWait time.Duration // Сколько ждать следующее сообщение пачки
+	process   func(msgs []*nats.Msg)
+	wg        sync.WaitGroup
+	mutex     sync.RWMutex // Защищает закрытие очереди от одновременной постановки сообщений
+	closed    bool
+	stop      chan struct{} // Закрывается в начале close и прерывает ожидание места в очереди
+
+	processed    atomic.Uint64
+	waited       atomic.Uint64
+	rejected     atomic.Uint64
+	latencyTotal atomic.Int64 // Суммарное время обработки в наносекундах
+	latencyMax   atomic.Int64
+}
+
+// newWorkerPool создает и запускает пул обработчиков.
+func newWorkerPool(workers int, queueSize int, batchSize int, batchWait time.Duration, process func(msgs []*nats.Msg)) *workerPool {
+	p := &workerPool{
+		jobs:      make(chan *nats.Msg, queueSize),
+		workers:   workers,
+		batchSize: batchSize,
+		batchWait: batchWait,
+		process:   process,
+		stop:      make(chan struct{}),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// run обрабатывает сообщения из очереди, пока она не будет закрыта.
+func (p *workerPool) run() {
+	defer p.wg.Done()
+	for msg := range p.jobs {
+		batch := p.collect(msg)
+		start := time.Now()
+		p.process(batch)
+		p.observe(len(batch), time.Since(start))
+	}
+}
+
+// collect набирает пачку, начиная с сообщения first: сообщения берутся из очереди,
+// пока пачка не заполнится или пока следующее сообщение не придется ждать дольше batchWait.
+func (p *workerPool) collect(first *nats.Msg) []*nats.Msg {
+	batch := []*nats.Msg{first}
+	if p.batchSize <= 1 {
+		return batch
+	}
+
+	timer := time.NewTimer(p.batchWait)
+	defer timer.Stop()
+	for len(batch) < p.batchSize {
+		select {
+		case msg, ok := <-p.jobs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// observe учитывает время обработки пачки из count сообщений.
+// Время обработки сообщения пачки считается равным времени обработки всей пачки.
+func (p *workerPool) observe(count int, latency time.Duration) {
+	p.processed.Add(uint64(count))
+	p.latencyTotal.Add(int64(latency) * int64(count))
+	for {
+		max := p.latencyMax.Load()
+		if int64(latency) <= max || p.latencyMax.CompareAndSwap(max, int64(latency)) {
+			return
+		}
+	}
+}
+
+// submit ставит сообщение в очередь. Если очередь заполнена, ждет освобождения места,
+// вызывая wait каждые interval, чтобы продлить срок подтверждения сообщения.
+// Возвращает false, если пул остановлен.
+func (p *workerPool) submit(msg *nats.Msg, interval time.Duration, wait func()) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		p.rejected.Add(1)
+		return false
+	}
+
+	select {
+	case p.jobs <- msg:
+		return true
+	default:
+	}
+
+	p.waited.Add(1)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case p.jobs <- msg:
+			return true
+		case <-ticker.C:
+			wait()
+		case <-p.stop:
+			p.rejected.Add(1)
+			return false
+		}
+	}
+}
+
+// close прекращает прием сообщений и дожидается обработки уже поставленных в очередь.
+func (p *workerPool) close() {
+	// Ожидающие места в очереди submit освобождают блокировку
+	close(p.stop)
+	p.mutex.Lock()
+	p.closed = true
+	close(p.jobs)
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
+
+// stats возвращает счетчики пула.
+func (p *workerPool) stats() WorkerStats {
+	stats := WorkerStats{
+		Workers:       p.workers,
+		QueueDepth:    len(p.jobs),
+		QueueCapacity: cap(p.jobs),
+		Processed:     p.processed.Load(),
+		Waited:        p.waited.Load(),
+		Rejected:      p.rejected.Load(),
+		MaxLatencyMs:  float64(p.latencyMax.Load()) / float64(time.Millisecond),
+	}
+	if stats.Processed > 0 {
+		stats.AvgLatencyMs = float64(p.latencyTotal.Load()) / float64(stats.Processed) / float64(time.Millisecond)
+	}
+	return stats
+}
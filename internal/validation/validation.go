@@ -0,0 +1,131 @@
+package validation
+
+import (
+	"WBTech_L0/internal/database"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// emailPattern описывает адрес вида имя@домен.зона.
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	// phonePattern описывает номер телефона из цифр, пробелов, скобок и дефисов с необязательным '+'.
+	phonePattern = regexp.MustCompile(`^\+?[0-9][0-9()\- ]{4,19}$`)
+	// currencyPattern описывает трехбуквенный код валюты ISO 4217.
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// Violation описывает одно нарушение правил проверки заказа.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Violations - список нарушений. Непустой список является ошибкой.
+type Violations []Violation
+
+// Error возвращает все нарушения одной строкой.
+func (v Violations) Error() string {
+	parts := make([]string, 0, len(v))
+	for _, violation := range v {
+		parts = append(parts, violation.Field+": "+violation.Message)
+	}
+	return "заказ не прошел проверку: " + strings.Join(parts, "; ")
+}
+
+// validator накапливает нарушения.
+type validator struct {
+	violations Violations
+}
+
+func (v *validator) add(field string, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "обязательное поле")
+	}
+}
+
+func (v *validator) nonNegative(field string, value int) {
+	if value < 0 {
+		v.add(field, "не может быть отрицательным: %d", value)
+	}
+}
+
+func (v *validator) match(field string, value string, pattern *regexp.Regexp, what string) {
+	if value != "" && !pattern.MatchString(value) {
+		v.add(field, "некорректный %s: %q", what, value)
+	}
+}
+
+// Validate проверяет заказ перед сохранением в базу данных: обязательные поля, форматы
+// order_uid, email, телефона и кода валюты, неотрицательность сумм, наличие товаров,
+// а также согласованность данных: payment.goods_total должен совпадать с суммой
+// items[].total_price, а items[].track_number - с track_number заказа.
+// Возвращает nil, если нарушений нет.
+func Validate(order database.Order) Violations {
+	v := &validator{}
+
+	v.required("order_uid", order.OrderUID)
+	if order.OrderUID != "" && !database.ValidUID(order.OrderUID) {
+		v.add("order_uid", "допустимы латинские буквы, цифры, '-' и '_', не длиннее 64 символов")
+	}
+	v.required("track_number", order.TrackNumber)
+	v.required("entry", order.Entry)
+	v.required("locale", order.Locale)
+	v.required("delivery_service", order.DeliveryService)
+	v.required("date_created", order.DateCreated)
+	if order.DateCreated != "" {
+		if _, err := time.Parse(time.RFC3339, order.DateCreated); err != nil {
+			v.add("date_created", "ожидается дата в формате RFC 3339: %q", order.DateCreated)
+		}
+	}
+
+	delivery := order.Delivery
+	v.required("delivery.name", delivery.Name)
+	v.required("delivery.phone", delivery.Phone)
+	v.match("delivery.phone", delivery.Phone, phonePattern, "номер телефона")
+	v.required("delivery.city", delivery.City)
+	v.required("delivery.address", delivery.Address)
+	v.required("delivery.email", delivery.Email)
+	v.match("delivery.email", delivery.Email, emailPattern, "email")
+
+	payment := order.Payment
+	v.required("payment.transaction", payment.Transaction)
+	v.required("payment.currency", payment.Currency)
+	v.match("payment.currency", payment.Currency, currencyPattern, "код валюты")
+	v.required("payment.provider", payment.Provider)
+	v.nonNegative("payment.amount", payment.Amount)
+	v.nonNegative("payment.delivery_cost", payment.DeliveryCost)
+	v.nonNegative("payment.goods_total", payment.GoodsTotal)
+	v.nonNegative("payment.custom_fee", payment.CustomFee)
+
+	if len(order.Items) == 0 {
+		v.add("items", "заказ должен содержать хотя бы один товар")
+	}
+
+	itemsTotal := 0
+	for i, item := range order.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		v.required(field+".name", item.Name)
+		v.nonNegative(field+".price", item.Price)
+		v.nonNegative(field+".total_price", item.TotalPrice)
+		if item.Sale < 0 || item.Sale > 100 {
+			v.add(field+".sale", "скидка должна быть от 0 до 100: %d", item.Sale)
+		}
+		if item.TrackNumber != order.TrackNumber {
+			v.add(field+".track_number", "не совпадает с track_number заказа: %q", item.TrackNumber)
+		}
+		itemsTotal += item.TotalPrice
+	}
+
+	if len(order.Items) > 0 && payment.GoodsTotal != itemsTotal {
+		v.add("payment.goods_total", "не совпадает с суммой total_price товаров: %d != %d", payment.GoodsTotal, itemsTotal)
+	}
+
+	return v.violations
+}
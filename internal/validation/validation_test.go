@@ -0,0 +1,115 @@
+package validation
+
+import (
+	"WBTech_L0/internal/database"
+	"WBTech_L0/internal/database/repotest"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidOrder(t *testing.T) {
+	if violations := Validate(repotest.Order("b563feb7b2b84b6test")); violations != nil {
+		t.Fatalf("корректный заказ отклонен: %v", violations)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(o *database.Order)
+		field   string
+		message string // Подстрока сообщения о нарушении
+	}{
+		{"missing order_uid", func(o *database.Order) { o.OrderUID = "" }, "order_uid", "обязательное поле"},
+		{"invalid order_uid", func(o *database.Order) { o.OrderUID = "bad uid!" }, "order_uid", "латинские буквы"},
+		{"too long order_uid", func(o *database.Order) { o.OrderUID = strings.Repeat("a", 65) }, "order_uid", "не длиннее 64"},
+		{"missing track_number", func(o *database.Order) {
+			o.TrackNumber = ""
+			o.Items[0].TrackNumber = ""
+		}, "track_number", "обязательное поле"},
+		{"missing entry", func(o *database.Order) { o.Entry = " " }, "entry", "обязательное поле"},
+		{"missing locale", func(o *database.Order) { o.Locale = "" }, "locale", "обязательное поле"},
+		{"missing delivery_service", func(o *database.Order) { o.DeliveryService = "" }, "delivery_service", "обязательное поле"},
+		{"missing date_created", func(o *database.Order) { o.DateCreated = "" }, "date_created", "обязательное поле"},
+		{"invalid date_created", func(o *database.Order) { o.DateCreated = "26.11.2021" }, "date_created", "RFC 3339"},
+		{"missing delivery.name", func(o *database.Order) { o.Delivery.Name = "" }, "delivery.name", "обязательное поле"},
+		{"missing delivery.phone", func(o *database.Order) { o.Delivery.Phone = "" }, "delivery.phone", "обязательное поле"},
+		{"invalid delivery.phone", func(o *database.Order) { o.Delivery.Phone = "phone" }, "delivery.phone", "номер телефона"},
+		{"short delivery.phone", func(o *database.Order) { o.Delivery.Phone = "+123" }, "delivery.phone", "номер телефона"},
+		{"missing delivery.city", func(o *database.Order) { o.Delivery.City = "" }, "delivery.city", "обязательное поле"},
+		{"missing delivery.address", func(o *database.Order) { o.Delivery.Address = "" }, "delivery.address", "обязательное поле"},
+		{"missing delivery.email", func(o *database.Order) { o.Delivery.Email = "" }, "delivery.email", "обязательное поле"},
+		{"invalid delivery.email", func(o *database.Order) { o.Delivery.Email = "test@gmail" }, "delivery.email", "email"},
+		{"missing payment.transaction", func(o *database.Order) { o.Payment.Transaction = "" }, "payment.transaction", "обязательное поле"},
+		{"missing payment.currency", func(o *database.Order) { o.Payment.Currency = "" }, "payment.currency", "обязательное поле"},
+		{"lowercase payment.currency", func(o *database.Order) { o.Payment.Currency = "usd" }, "payment.currency", "код валюты"},
+		{"long payment.currency", func(o *database.Order) { o.Payment.Currency = "USDT" }, "payment.currency", "код валюты"},
+		{"missing payment.provider", func(o *database.Order) { o.Payment.Provider = "" }, "payment.provider", "обязательное поле"},
+		{"negative payment.amount", func(o *database.Order) { o.Payment.Amount = -1 }, "payment.amount", "отрицательным"},
+		{"negative payment.delivery_cost", func(o *database.Order) { o.Payment.DeliveryCost = -1 }, "payment.delivery_cost", "отрицательным"},
+		{"negative payment.custom_fee", func(o *database.Order) { o.Payment.CustomFee = -1 }, "payment.custom_fee", "отрицательным"},
+		{"empty items", func(o *database.Order) { o.Items = nil }, "items", "хотя бы один товар"},
+		{"missing items[0].name", func(o *database.Order) { o.Items[0].Name = "" }, "items[0].name", "обязательное поле"},
+		{"negative items[0].price", func(o *database.Order) { o.Items[0].Price = -1 }, "items[0].price", "отрицательным"},
+		{"sale over 100", func(o *database.Order) { o.Items[0].Sale = 101 }, "items[0].sale", "от 0 до 100"},
+		{"goods_total mismatch", func(o *database.Order) { o.Payment.GoodsTotal = 300 }, "payment.goods_total", "300 != 317"},
+		{"items track_number mismatch", func(o *database.Order) {
+			o.Items = append(o.Items, o.Items[0])
+			o.Items[1].TrackNumber = "OTHER"
+			o.Payment.GoodsTotal = 634
+		}, "items[1].track_number", `"OTHER"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := repotest.Order("b563feb7b2b84b6test")
+			tt.mutate(&order)
+
+			violations := Validate(order)
+			if len(violations) != 1 {
+				t.Fatalf("нарушений: %d (%v), ожидается одно для %s", len(violations), violations, tt.field)
+			}
+			if violations[0].Field != tt.field {
+				t.Fatalf("поле %q, ожидается %q", violations[0].Field, tt.field)
+			}
+			if !strings.Contains(violations[0].Message, tt.message) {
+				t.Fatalf("сообщение %q не содержит %q", violations[0].Message, tt.message)
+			}
+		})
+	}
+}
+
+// Нарушения накапливаются по всем полям и сериализуются в JSON как список пар field/message.
+func TestViolationsStructure(t *testing.T) {
+	order := repotest.Order("b563feb7b2b84b6test")
+	order.Delivery.Email = "not-an-email"
+	order.Payment.Amount = -5
+	order.Items[0].TotalPrice = -1
+
+	violations := Validate(order)
+	want := []Violation{
+		{Field: "delivery.email", Message: `некорректный email: "not-an-email"`},
+		{Field: "payment.amount", Message: "не может быть отрицательным: -5"},
+		{Field: "items[0].total_price", Message: "не может быть отрицательным: -1"},
+		{Field: "payment.goods_total", Message: "не совпадает с суммой total_price товаров: 317 != -1"},
+	}
+	if len(violations) != len(want) {
+		t.Fatalf("нарушения %v, ожидается %v", violations, want)
+	}
+	for i := range want {
+		if violations[i] != want[i] {
+			t.Fatalf("нарушение %d: %+v, ожидается %+v", i, violations[i], want[i])
+		}
+	}
+
+	var err error = violations
+	if !strings.HasPrefix(err.Error(), "заказ не прошел проверку: delivery.email: ") {
+		t.Fatalf("Error() = %q", err.Error())
+	}
+
+	data, _ := json.Marshal(violations[:1])
+	if string(data) != `[{"field":"delivery.email","message":"некорректный email: \"not-an-email\""}]` {
+		t.Fatalf("JSON нарушений: %s", data)
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
+	"WBTech_L0/internal/streaming"
+
 	"github.com/ddosify/go-faker/faker"
-	"github.com/nats-io/nats.go"
 )
 
 // Delivery представляет информацию о доставке.
@@ -70,17 +72,30 @@ type Order struct {
 }
 
 func main() {
-	nc, err := nats.Connect(nats.DefaultURL)
+	nc, err := streaming.Connect(context.Background(), streaming.ConnConfigFromEnv())
 	if err != nil {
 		log.Fatalf("can't connect to NATS: %v", err)
 	}
 	defer nc.Close()
 
+	// Публикуем через JetStream, чтобы заказы сохранялись, пока сервис не запущен
+	js, err := nc.JetStream()
+	if err != nil {
+		log.Fatalf("JetStream is not available: %v", err)
+	}
+	if err := streaming.EnsureOrdersStream(js); err != nil {
+		log.Fatalf("can't create orders stream: %v", err)
+	}
+
 	// Публикация в канал
 	count := 0
 	for {
 		randomJSON := GenerateRandomJSONData()
-		nc.Publish("intros", []byte(randomJSON))
+		if _, err := js.Publish(streaming.OrdersSubject(), []byte(randomJSON)); err != nil {
+			log.Printf("can't publish JSON: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
 		count++
 		log.Printf("sent JSON %v", count)
 		time.Sleep(5 * time.Second)
@@ -137,10 +152,20 @@ func GenerateRandomJSONData() string {
 		DeliveryService:   faker.RandomCompanyName(),
 		Shardkey:          faker.IntBetween(0, 10),
 		SMID:              faker.IntBetween(10, 100),
-		DateCreated:       faker.RandomDatePast(),
+		DateCreated:       randomDateCreated(faker),
 		OofShard:          faker.IntBetween(0, 10),
 	}
 
+	// Согласуем товары и суммы платежа, чтобы заказ прошел проверку сервиса
+	order.Payment.GoodsTotal = 0
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.TrackNumber = order.TrackNumber
+		item.TotalPrice = item.Price * (100 - item.Sale) / 100
+		order.Payment.GoodsTotal += item.TotalPrice
+	}
+	order.Payment.Amount = order.Payment.GoodsTotal + order.Payment.DeliveryCost + order.Payment.CustomFee
+
 	jsonData, err := json.MarshalIndent(order, "", " ")
 	if err != nil {
 		fmt.Println("Error:", err)
@@ -148,3 +173,12 @@ func GenerateRandomJSONData() string {
 
 	return string(jsonData)
 }
+
+// randomDateCreated возвращает случайную дату в прошлом в формате RFC 3339.
+func randomDateCreated(f faker.Faker) string {
+	date, err := time.Parse(time.UnixDate, f.RandomDatePast())
+	if err != nil {
+		return time.Now().UTC().Format(time.RFC3339)
+	}
+	return date.UTC().Format(time.RFC3339)
+}